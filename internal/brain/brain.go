@@ -0,0 +1,691 @@
+// Package brain defines the AI adapter interface and implementations.
+// An adapter turns a prompt into a Response; the Claude adapter shells
+// out to the Claude Code CLI, others are placeholders.
+package brain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/aid/agentic/internal/bundle"
+	"github.com/aid/agentic/internal/graph"
+)
+
+// ErrBrainRefused marks responses where the model declined or failed to
+// do the task rather than legitimately having nothing to change. The
+// CLI uses it to distinguish "nothing to do" from "the model declined".
+var ErrBrainRefused = errors.New("brain declined the request")
+
+// Response is the parsed result of a brain call.
+type Response struct {
+	// Message is the model's prose output with file blocks removed.
+	Message string
+	// Files maps extracted file paths to their complete new content.
+	Files map[string]string
+	// Deletes lists paths the model asked to delete.
+	Deletes []string
+	// Cost is the reported cost of the call in USD, if known.
+	Cost float64
+	// Raw is the unprocessed model output as received, kept for
+	// transcript logging and extraction debugging.
+	Raw string
+}
+
+// Adapter is a pluggable AI backend.
+type Adapter interface {
+	Name() string
+	Call(prompt string) (*Response, error)
+}
+
+// registry holds the available adapters by name.
+var registry = map[string]Adapter{
+	"claude": &ClaudeAdapter{},
+	"gemini": &GeminiAdapter{},
+	"codex":  &CodexAdapter{},
+	"mock":   &MockAdapter{},
+}
+
+// Get returns the named adapter, or an error listing what exists.
+func Get(name string) (Adapter, error) {
+	if a, ok := registry[name]; ok {
+		return a, nil
+	}
+	names := make([]string, 0, len(registry))
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown brain adapter %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// DefaultAdapterName resolves the adapter to use from AGENTIC_BRAIN,
+// defaulting to claude.
+func DefaultAdapterName() string {
+	if name := os.Getenv("AGENTIC_BRAIN"); name != "" {
+		return name
+	}
+	return "claude"
+}
+
+// FallbackNames resolves the adapter fallback chain from
+// AGENTIC_BRAIN_FALLBACK, a comma-separated list tried in order
+// (e.g. "ollama,claude"). Empty means no fallback.
+func FallbackNames() []string {
+	raw := os.Getenv("AGENTIC_BRAIN_FALLBACK")
+	if raw == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// rateLimiter is a token bucket shared by all brain calls in the
+// process, so sequential runs and the parallel worker pool alike stay
+// under a calls-per-minute cap.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int // calls per minute; 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+// limiter is initialized once from AGENTIC_RATE_LIMIT (calls per
+// minute); unset or invalid means no throttling.
+var (
+	limiter     rateLimiter
+	limiterOnce sync.Once
+)
+
+// wait blocks until the bucket has a token available, then takes it.
+func (l *rateLimiter) wait() {
+	if l.limit <= 0 {
+		return
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Minutes() * float64(l.limit)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - l.tokens) / float64(l.limit) * float64(time.Minute))
+		l.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// initLimiter sets the shared limiter from the environment.
+func initLimiter() {
+	raw := os.Getenv("AGENTIC_RATE_LIMIT")
+	if raw == "" {
+		return
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		fmt.Fprintf(os.Stderr, "warning: ignoring invalid AGENTIC_RATE_LIMIT %q\n", raw)
+		return
+	}
+	limiter.limit = n
+	limiter.tokens = float64(n)
+	limiter.last = time.Now()
+}
+
+// Call invokes the named adapter with a prompt and parses the result.
+// When a fallback chain is configured it is used instead of the single
+// named adapter. Calls pass through the shared rate limiter.
+func Call(name, prompt string) (*Response, error) {
+	return CallInDir(name, prompt, "")
+}
+
+// CallInDir is Call with a working directory for the adapter process,
+// so shell-out brains run relative to the node they are editing. An
+// empty dir or an adapter without directory support falls back to the
+// current directory.
+func CallInDir(name, prompt, dir string) (*Response, error) {
+	limiterOnce.Do(initLimiter)
+	limiter.wait()
+	if chain := FallbackNames(); len(chain) > 0 {
+		return (&FallbackAdapter{Names: chain}).CallInDir(prompt, dir)
+	}
+	adapter, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return callAdapter(adapter, prompt, dir)
+}
+
+// DirAdapter is implemented by adapters whose process can run from a
+// specific working directory.
+type DirAdapter interface {
+	CallInDir(prompt, dir string) (*Response, error)
+}
+
+// callAdapter dispatches through CallInDir when the adapter supports
+// a working directory, else the plain Call.
+func callAdapter(adapter Adapter, prompt, dir string) (*Response, error) {
+	if da, ok := adapter.(DirAdapter); ok {
+		return da.CallInDir(prompt, dir)
+	}
+	return adapter.Call(prompt)
+}
+
+// FallbackAdapter is a composite adapter that tries each named adapter
+// in order until one succeeds. A refusal is not retried: it means the
+// adapter itself worked and the model declined.
+type FallbackAdapter struct {
+	Names []string
+}
+
+func (a *FallbackAdapter) Name() string { return "fallback(" + strings.Join(a.Names, ",") + ")" }
+
+func (a *FallbackAdapter) Call(prompt string) (*Response, error) {
+	return a.CallInDir(prompt, "")
+}
+
+func (a *FallbackAdapter) CallInDir(prompt, dir string) (*Response, error) {
+	var lastErr error
+	for i, name := range a.Names {
+		adapter, err := Get(name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := callAdapter(adapter, prompt, dir)
+		if err == nil || errors.Is(err, ErrBrainRefused) {
+			if i > 0 {
+				fmt.Fprintf(os.Stderr, "brain: fell back to %s\n", name)
+			}
+			return resp, err
+		}
+		lastErr = err
+		if i < len(a.Names)-1 {
+			fmt.Fprintf(os.Stderr, "brain: %s failed (%v), trying %s\n", name, err, a.Names[i+1])
+		}
+	}
+	return nil, fmt.Errorf("all brain adapters failed: %w", lastErr)
+}
+
+// Verbose makes shell-out adapters tee the model's stdout to the
+// terminal as it arrives, so long generations show progress instead of
+// going silent until the process exits. Set by the CLI from --verbose.
+var Verbose bool
+
+// ClaudeAdapter shells out to the Claude Code CLI.
+type ClaudeAdapter struct{}
+
+func (a *ClaudeAdapter) Name() string { return "claude" }
+
+// Call runs the claude CLI with the prompt on stdin. Output is always
+// buffered for ExtractFiles; with Verbose set it is additionally
+// streamed to the terminal while the process runs.
+func (a *ClaudeAdapter) Call(prompt string) (*Response, error) {
+	return a.CallInDir(prompt, "")
+}
+
+// claudeResult is the envelope `claude -p --output-format json` prints:
+// the final text plus call metadata, of which only the cost matters
+// here.
+type claudeResult struct {
+	Result       string  `json:"result"`
+	TotalCostUSD float64 `json:"total_cost_usd"`
+}
+
+// CallInDir runs the claude CLI from dir, so the model's own tool use
+// is relative to the node being edited. An empty dir means the
+// current directory. Output is requested as JSON so the reported cost
+// reaches Response.Cost; plain-text output from CLIs that ignore the
+// flag still extracts, just without a cost.
+func (a *ClaudeAdapter) CallInDir(prompt, dir string) (*Response, error) {
+	cmd := exec.Command("claude", "-p", "--output-format", "json")
+	cmd.Stdin = strings.NewReader(prompt)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if Verbose {
+		cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
+	}
+	cmd.Stderr = &stderr
+
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		dir = cwd
+	}
+	cmd.Dir = dir
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("claude CLI failed: %w\n%s", err, stderr.String())
+	}
+
+	return parseClaudeOutput(stdout.String())
+}
+
+// parseClaudeOutput decodes the CLI's JSON envelope when present,
+// extracting files from the result text and carrying the cost over;
+// anything that is not the envelope is treated as plain model output.
+func parseClaudeOutput(out string) (*Response, error) {
+	var envelope claudeResult
+	if err := json.Unmarshal([]byte(out), &envelope); err == nil && envelope.Result != "" {
+		resp, err := ExtractFiles(envelope.Result)
+		if err != nil {
+			return nil, err
+		}
+		resp.Cost = envelope.TotalCostUSD
+		return resp, nil
+	}
+	return ExtractFiles(out)
+}
+
+// CheckAvailable verifies the claude CLI is installed and on PATH.
+func (a *ClaudeAdapter) CheckAvailable() error {
+	if _, err := exec.LookPath("claude"); err != nil {
+		return fmt.Errorf("claude CLI not found on PATH; install Claude Code first")
+	}
+	return nil
+}
+
+// GeminiAdapter is a placeholder for Google Gemini support.
+type GeminiAdapter struct{}
+
+func (a *GeminiAdapter) Name() string { return "gemini" }
+
+func (a *GeminiAdapter) Call(prompt string) (*Response, error) {
+	return nil, fmt.Errorf("gemini adapter not implemented yet")
+}
+
+// CodexAdapter is a placeholder for OpenAI Codex support.
+type CodexAdapter struct{}
+
+func (a *CodexAdapter) Name() string { return "codex" }
+
+func (a *CodexAdapter) Call(prompt string) (*Response, error) {
+	return nil, fmt.Errorf("codex adapter not implemented yet")
+}
+
+// MockAdapter returns a deterministic canned response for tests and
+// offline pipeline verification.
+type MockAdapter struct{}
+
+func (a *MockAdapter) Name() string { return "mock" }
+
+func (a *MockAdapter) Call(prompt string) (*Response, error) {
+	return &Response{
+		Message: "mock adapter response",
+		Files: map[string]string{
+			"MOCK.txt": "mock change generated for prompt of " + fmt.Sprint(len(prompt)) + " chars\n",
+		},
+	}, nil
+}
+
+// BuildPrompt assembles the full prompt for a node task: constraints
+// from metadata, the current bundle content, and output format
+// instructions.
+func BuildPrompt(node *graph.Node, b *bundle.Bundle, request string) string {
+	var sb strings.Builder
+
+	sb.WriteString("You are working on a focused node of a larger codebase.\n\n")
+	fmt.Fprintf(&sb, "NODE: %s\n", node.ID)
+
+	if node.Meta != nil {
+		if node.Meta.Purpose != "" {
+			fmt.Fprintf(&sb, "PURPOSE: %s\n", node.Meta.Purpose)
+		}
+		if len(node.Meta.Invariants) > 0 || len(node.Meta.NonGoals) > 0 || len(node.Meta.Policies.AllowedPaths) > 0 {
+			sb.WriteString("\nCONSTRAINTS (must hold after your changes):\n")
+			for _, inv := range node.Meta.Invariants {
+				fmt.Fprintf(&sb, "- %s\n", inv)
+			}
+			for _, ng := range node.Meta.NonGoals {
+				fmt.Fprintf(&sb, "- Non-goal, do not attempt: %s\n", ng)
+			}
+			if len(node.Meta.Policies.AllowedPaths) > 0 {
+				fmt.Fprintf(&sb, "- Only create or modify files matching these paths; anything else will be discarded: %s\n",
+					strings.Join(node.Meta.Policies.AllowedPaths, ", "))
+			}
+		}
+	}
+
+	sb.WriteString("\n--- CURRENT FILES ---\n\n")
+	paths := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&sb, "=== FILE: %s ===\n%s\n=== END FILE ===\n\n", path, b.Files[path])
+	}
+
+	if len(b.Truncated) > 0 {
+		fmt.Fprintf(&sb, "[truncated: omitted %d file(s) to fit the token budget: %s]\n\n",
+			len(b.Truncated), strings.Join(b.Truncated, ", "))
+	}
+
+	if len(b.Contracts) > 0 {
+		sb.WriteString("--- DEPENDENCY CONTRACTS (interfaces you must match, do not modify) ---\n\n")
+		names := make([]string, 0, len(b.Contracts))
+		for name := range b.Contracts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		remaining := maxContractChars
+		for _, name := range names {
+			content := b.Contracts[name]
+			if len(content) > remaining {
+				fmt.Fprintf(&sb, "=== CONTRACT: %s ===\n[contract truncated to fit the token budget]\n=== END CONTRACT ===\n\n", name)
+				continue
+			}
+			remaining -= len(content)
+			fmt.Fprintf(&sb, "=== CONTRACT: %s ===\n%s\n=== END CONTRACT ===\n\n", name, content)
+		}
+	}
+
+	sb.WriteString("--- TASK ---\n")
+	sb.WriteString(request)
+	sb.WriteString("\n\n--- OUTPUT FORMAT ---\n")
+	sb.WriteString("For every file you change or create, output the COMPLETE new content as:\n\n")
+	sb.WriteString("=== FILE: path/to/file ===\n<complete file content>\n=== END FILE ===\n\n")
+	sb.WriteString("To delete a file, output on its own line:\n\n")
+	sb.WriteString("=== DELETE FILE: path/to/file ===\n\n")
+	sb.WriteString("Do not output diffs or partial files. Files you do not change must not be output.\n")
+
+	return sb.String()
+}
+
+// maxContractChars caps the prompt space given to dependency
+// contracts: roughly 8k tokens at the heuristic 4 chars/token.
+// Contracts that would blow past it are replaced with a marker rather
+// than silently dropped.
+const maxContractChars = 32768
+
+// fileBlockRe matches a complete file block in model output.
+// The delimiters tolerate the spacing and casing drift models emit —
+// `===FILE:`, `=== FILE :`, trailing spaces, lowercase — so a sloppy
+// response still extracts instead of dumping whole files into Message.
+var fileBlockRe = regexp.MustCompile(`(?is)===\s*FILE\s*:\s*(.+?)\s*===[ \t]*\n(.*?)===\s*END\s*FILE\s*===`)
+
+// fileOpenRe matches just an opening file delimiter, for truncation
+// accounting. The colon keeps it from also matching END FILE lines.
+var fileOpenRe = regexp.MustCompile(`(?i)===\s*FILE\s*:`)
+
+// deleteBlockRe matches a file deletion directive in model output,
+// with the same tolerance for spacing and casing drift as fileBlockRe.
+var deleteBlockRe = regexp.MustCompile(`(?i)===\s*DELETE\s+FILE\s*:\s*(.+?)\s*===`)
+
+// ExtractFiles parses model output into a Response, pulling out file
+// blocks and validating Go syntax of extracted .go files.
+func ExtractFiles(raw string) (*Response, error) {
+	output := sanitizeOutput(raw)
+
+	resp := &Response{Files: make(map[string]string), Raw: raw}
+
+	matches := fileBlockRe.FindAllStringSubmatchIndex(output, -1)
+	for _, m := range matches {
+		path := strings.TrimSpace(output[m[2]:m[3]])
+		content := stripFileFences(output[m[4]:m[5]])
+		content = strings.TrimSuffix(content, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		// Models sometimes emit the same path twice, e.g. a corrected
+		// second version. Keep the last occurrence, but say so rather
+		// than silently staging whichever block happened to win.
+		if _, dup := resp.Files[path]; dup {
+			fmt.Fprintf(os.Stderr, "warning: response contains %s more than once; keeping the last occurrence\n", path)
+		}
+		resp.Files[path] = content
+	}
+
+	// Deletion directives are parsed outside file blocks so content that
+	// happens to mention the directive is not misread.
+	withoutFiles := fileBlockRe.ReplaceAllString(output, "")
+	for _, m := range deleteBlockRe.FindAllStringSubmatch(withoutFiles, -1) {
+		resp.Deletes = append(resp.Deletes, strings.TrimSpace(m[1]))
+	}
+	sort.Strings(resp.Deletes)
+
+	resp.Message = strings.TrimSpace(deleteBlockRe.ReplaceAllString(withoutFiles, ""))
+
+	if len(resp.Files) == 0 && len(resp.Deletes) == 0 && isRefusal(resp.Message) {
+		return resp, fmt.Errorf("%w: %s", ErrBrainRefused, firstLine(resp.Message))
+	}
+
+	if err := detectTruncation(output, matches); err != nil {
+		return resp, err
+	}
+
+	for path, content := range resp.Files {
+		if validate, ok := fileValidators[strings.ToLower(filepath.Ext(path))]; ok {
+			if err := validate(path, content); err != nil {
+				return resp, err
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+// fileValidators maps file extensions to syntax validators run over
+// extracted content, so a malformed file the model hallucinated is
+// rejected before it can be staged. Keyed by lowercased extension;
+// RegisterValidator adds more formats.
+var fileValidators = map[string]func(path, content string) error{
+	".go":   validateGoSyntax,
+	".json": validateJSONSyntax,
+	".yaml": validateYAMLSyntax,
+	".yml":  validateYAMLSyntax,
+}
+
+// RegisterValidator installs (or replaces) the syntax validator for a
+// file extension (leading dot required, e.g. ".toml").
+func RegisterValidator(ext string, validate func(path, content string) error) {
+	fileValidators[strings.ToLower(ext)] = validate
+}
+
+// refusalPhrases are markers of refusal- or error-style prose. The
+// check is heuristic: it only fires when no file blocks were emitted,
+// so false positives on legitimate no-op responses are unlikely.
+var refusalPhrases = []string{
+	"i can't",
+	"i cannot",
+	"i won't",
+	"i'm sorry",
+	"i am sorry",
+	"i apologize",
+	"i'm unable",
+	"i am unable",
+	"unable to comply",
+	"as an ai",
+	"error:",
+	"an error occurred",
+}
+
+// isRefusal reports whether a message without file blocks reads like a
+// refusal or error explanation rather than a legitimate no-op.
+func isRefusal(message string) bool {
+	if message == "" {
+		return false
+	}
+	lower := strings.ToLower(message)
+	for _, phrase := range refusalPhrases {
+		if strings.Contains(lower, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLine returns the first non-empty line of a message.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" {
+			return trimmed
+		}
+	}
+	return s
+}
+
+// sanitizeOutput strips a markdown code fence wrapping the entire
+// output, which models sometimes add around the whole response.
+func sanitizeOutput(raw string) string {
+	out := strings.TrimSpace(raw)
+	if strings.HasPrefix(out, "```") {
+		if idx := strings.Index(out, "\n"); idx >= 0 {
+			out = out[idx+1:]
+		}
+		out = strings.TrimSuffix(strings.TrimSpace(out), "```")
+	}
+	return out
+}
+
+// innerFenceRe matches an opening code fence line, with or without a
+// language hint like ```go or ```golang.
+var innerFenceRe = regexp.MustCompile("^```[a-zA-Z0-9+_.-]*$")
+
+// stripFileFences removes a markdown code fence wrapping one file's
+// content inside a === FILE: block, which models frequently add even
+// though the delimiters already mark the boundaries. Only a matched
+// leading/trailing pair is stripped; fences in the middle of a file
+// are content and stay put.
+func stripFileFences(content string) string {
+	lines := strings.Split(content, "\n")
+	first := 0
+	for first < len(lines) && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+	last := len(lines) - 1
+	for last >= 0 && strings.TrimSpace(lines[last]) == "" {
+		last--
+	}
+	if first >= last {
+		return content
+	}
+	if !innerFenceRe.MatchString(strings.TrimSpace(lines[first])) ||
+		strings.TrimSpace(lines[last]) != "```" {
+		return content
+	}
+	return strings.Join(lines[first+1:last], "\n") + "\n"
+}
+
+// detectTruncation errors when the output contains an opening file
+// delimiter without a matching END, indicating a cut-off response.
+func detectTruncation(output string, matches [][]int) error {
+	opens := len(fileOpenRe.FindAllString(output, -1))
+	if opens > len(matches) {
+		return fmt.Errorf("response appears truncated: %d file blocks opened, %d closed", opens, len(matches))
+	}
+	return nil
+}
+
+// stubMarkers are phrases indicating the model returned placeholder
+// code instead of an implementation.
+var stubMarkers = []string{
+	"todo: implement",
+	"todo implement",
+	"not implemented",
+	"unimplemented",
+	"fixme: implement",
+	"implementation goes here",
+}
+
+// DetectStubs heuristically scans extracted files for signs the model
+// stubbed out the work: stub-marker comments anywhere, or Go functions
+// with empty bodies. Returns one warning per finding; these are
+// advisory, since a legitimate change can contain a TODO.
+func DetectStubs(files map[string]string) []string {
+	var warnings []string
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		content := files[path]
+		lower := strings.ToLower(content)
+		for _, marker := range stubMarkers {
+			if strings.Contains(lower, marker) {
+				warnings = append(warnings, fmt.Sprintf("%s contains %q", path, marker))
+				break
+			}
+		}
+		if strings.HasSuffix(path, ".go") {
+			warnings = append(warnings, emptyGoFuncs(path, content)...)
+		}
+	}
+	return warnings
+}
+
+// emptyGoFuncs reports functions with empty bodies in Go content.
+// Unparseable content is skipped; validateGoSyntax already rejects it.
+func emptyGoFuncs(path, content string) []string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, 0)
+	if err != nil {
+		return nil
+	}
+	var warnings []string
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && len(fn.Body.List) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: function %s has an empty body", path, fn.Name.Name))
+		}
+	}
+	return warnings
+}
+
+// validateGoSyntax parses Go content and rejects files that do not
+// compile syntactically, catching malformed model output early.
+func validateGoSyntax(path, content string) error {
+	fset := token.NewFileSet()
+	if _, err := parser.ParseFile(fset, path, content, parser.ParseComments); err != nil {
+		return fmt.Errorf("extracted file %s has invalid Go syntax: %w", path, err)
+	}
+	return nil
+}
+
+// validateJSONSyntax rejects extracted .json files that do not parse.
+func validateJSONSyntax(path, content string) error {
+	var v interface{}
+	if err := json.Unmarshal([]byte(content), &v); err != nil {
+		return fmt.Errorf("extracted file %s has invalid JSON syntax: %w", path, err)
+	}
+	return nil
+}
+
+// validateYAMLSyntax rejects extracted .yaml/.yml files that do not
+// parse.
+func validateYAMLSyntax(path, content string) error {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(content), &v); err != nil {
+		return fmt.Errorf("extracted file %s has invalid YAML syntax: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package brain
+
+import "testing"
+
+// TestExtractFilesInnerFences covers the malformed-but-common shapes
+// models emit: individual files wrapped in markdown fences inside the
+// === FILE: delimiters, with and without language hints. Fences in the
+// middle of a file are content and must survive.
+func TestExtractFilesInnerFences(t *testing.T) {
+	const want = "package demo\n\nfunc Demo() {}\n"
+
+	cases := []struct {
+		name string
+		path string // defaults to demo.go
+		body string
+		want string
+	}{
+		{
+			name: "no fence",
+			body: "package demo\n\nfunc Demo() {}\n",
+			want: want,
+		},
+		{
+			name: "plain fence",
+			body: "```\npackage demo\n\nfunc Demo() {}\n```\n",
+			want: want,
+		},
+		{
+			name: "go hint",
+			body: "```go\npackage demo\n\nfunc Demo() {}\n```\n",
+			want: want,
+		},
+		{
+			name: "golang hint",
+			body: "```golang\npackage demo\n\nfunc Demo() {}\n```\n",
+			want: want,
+		},
+		{
+			name: "blank lines around fence",
+			body: "\n```go\npackage demo\n\nfunc Demo() {}\n```\n\n",
+			want: want,
+		},
+		{
+			name: "unmatched opening fence is content",
+			path: "notes.txt",
+			body: "```go\npackage demo\n\nfunc Demo() {}\n",
+			want: "```go\npackage demo\n\nfunc Demo() {}\n",
+		},
+		{
+			name: "interior fence preserved",
+			body: "package demo\n\n// Usage:\n//\n//\t```\n//\tdemo.Demo()\n//\t```\nfunc Demo() {}\n",
+			want: "package demo\n\n// Usage:\n//\n//\t```\n//\tdemo.Demo()\n//\t```\nfunc Demo() {}\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path
+			if path == "" {
+				path = "demo.go"
+			}
+			raw := "=== FILE: " + path + " ===\n" + tc.body + "=== END FILE ===\n"
+			resp, err := ExtractFiles(raw)
+			if err != nil {
+				t.Fatalf("ExtractFiles: %v", err)
+			}
+			got, ok := resp.Files[path]
+			if !ok {
+				t.Fatalf("%s not extracted; files: %v", path, resp.Files)
+			}
+			if got != tc.want {
+				t.Errorf("content = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestExtractFilesDelimiterVariants covers the spacing and casing
+// drift models emit in the === FILE: delimiters. Every variant must
+// extract the file rather than dumping it into Message.
+func TestExtractFilesDelimiterVariants(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"canonical", "=== FILE: demo.go ===\npackage demo\n=== END FILE ==="},
+		{"no space after equals", "===FILE: demo.go ===\npackage demo\n===END FILE==="},
+		{"space before colon", "=== FILE : demo.go ===\npackage demo\n=== END FILE ==="},
+		{"trailing spaces", "=== FILE: demo.go ===   \npackage demo\n=== END FILE ===  "},
+		{"lowercase", "=== file: demo.go ===\npackage demo\n=== end file ==="},
+		{"no space in end", "=== FILE: demo.go ===\npackage demo\n=== ENDFILE ==="},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := ExtractFiles(tc.raw)
+			if err != nil {
+				t.Fatalf("ExtractFiles: %v", err)
+			}
+			if got := resp.Files["demo.go"]; got != "package demo\n" {
+				t.Errorf("content = %q, want %q (files: %v)", got, "package demo\n", resp.Files)
+			}
+		})
+	}
+
+	deleteCases := []struct {
+		name string
+		raw  string
+	}{
+		{"canonical delete", "=== DELETE FILE: old.go ==="},
+		{"no space after equals", "===DELETE FILE: old.go ==="},
+		{"space before colon", "=== DELETE FILE : old.go ==="},
+		{"lowercase delete", "=== delete file: old.go ==="},
+		{"extra spaces", "===  DELETE  FILE:  old.go  ==="},
+	}
+	for _, tc := range deleteCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := ExtractFiles(tc.raw)
+			if err != nil {
+				t.Fatalf("ExtractFiles: %v", err)
+			}
+			if len(resp.Deletes) != 1 || resp.Deletes[0] != "old.go" {
+				t.Errorf("Deletes = %v, want [old.go]", resp.Deletes)
+			}
+		})
+	}
+}
+
+// TestDetectTruncationVariants checks truncation accounting still sees
+// relaxed opening delimiters that never closed.
+func TestDetectTruncationVariants(t *testing.T) {
+	raw := "=== FILE: a.go ===\npackage a\n=== END FILE ===\n===FILE: b.go ===\npackage b\n"
+	if _, err := ExtractFiles(raw); err == nil {
+		t.Error("expected truncation error for unclosed ===FILE: block")
+	}
+}
+
+// TestExtractFilesFormatValidation checks that malformed JSON and YAML
+// are rejected during extraction while valid content passes.
+func TestExtractFilesFormatValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		path    string
+		body    string
+		wantErr bool
+	}{
+		{"valid json", "config.json", "{\"key\": 1}\n", false},
+		{"invalid json", "config.json", "{\"key\": }\n", true},
+		{"valid yaml", "config.yaml", "key: value\nlist:\n  - a\n", false},
+		{"invalid yaml", "config.yml", "key: [unclosed\n", true},
+		{"unknown extension unvalidated", "notes.txt", "{{{ not anything\n", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := "=== FILE: " + tc.path + " ===\n" + tc.body + "=== END FILE ===\n"
+			_, err := ExtractFiles(raw)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected validation error for %s", tc.path)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error for %s: %v", tc.path, err)
+			}
+		})
+	}
+}
+
+// TestSanitizeOutputWholeResponseFence checks the existing whole-output
+// fence stripping still composes with per-file stripping.
+func TestSanitizeOutputWholeResponseFence(t *testing.T) {
+	raw := "```\n=== FILE: demo.go ===\n```go\npackage demo\n```\n=== END FILE ===\n```"
+	resp, err := ExtractFiles(raw)
+	if err != nil {
+		t.Fatalf("ExtractFiles: %v", err)
+	}
+	if got := resp.Files["demo.go"]; got != "package demo\n" {
+		t.Errorf("content = %q, want %q", got, "package demo\n")
+	}
+}
+
+// TestParseClaudeOutput covers the CLI's JSON envelope: the cost must
+// reach the response and the result text must go through extraction,
+// while plain-text output (older CLIs) still extracts without a cost.
+func TestParseClaudeOutput(t *testing.T) {
+	const body = "=== FILE: demo.go ===\npackage demo\n=== END FILE ===\n"
+
+	envelope := `{"type":"result","result":"` +
+		`=== FILE: demo.go ===\npackage demo\n=== END FILE ===\n` +
+		`","total_cost_usd":0.042}`
+	resp, err := parseClaudeOutput(envelope)
+	if err != nil {
+		t.Fatalf("parseClaudeOutput(envelope): %v", err)
+	}
+	if resp.Cost != 0.042 {
+		t.Errorf("Cost = %v, want 0.042", resp.Cost)
+	}
+	if got := resp.Files["demo.go"]; got != "package demo\n" {
+		t.Errorf("content = %q, want %q", got, "package demo\n")
+	}
+
+	resp, err = parseClaudeOutput(body)
+	if err != nil {
+		t.Fatalf("parseClaudeOutput(text): %v", err)
+	}
+	if resp.Cost != 0 {
+		t.Errorf("plain text Cost = %v, want 0", resp.Cost)
+	}
+	if got := resp.Files["demo.go"]; got != "package demo\n" {
+		t.Errorf("content = %q, want %q", got, "package demo\n")
+	}
+}
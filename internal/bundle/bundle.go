@@ -0,0 +1,528 @@
+// Package bundle builds context bundles for brain prompts from node
+// content. A bundle is the full set of text files under a node's path
+// plus the contracts of its direct dependencies, with a reproducible
+// hash for cache invalidation.
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/token"
+	"github.com/aid/agentic/internal/workspace"
+)
+
+// ContractsDirName is the per-node directory holding exported contracts
+// that dependents include in their bundles.
+const ContractsDirName = "CONTRACTS"
+
+// cacheDir returns where built bundles are cached, keyed by node ID.
+// Honors AGENTIC_HOME via workspace.DataDir.
+func cacheDir() string {
+	return filepath.Join(workspace.DataDir(), "cache")
+}
+
+// metadataOverhead is the token overhead for bundle framing (delimiters,
+// node metadata rendered into the prompt).
+const metadataOverhead = 200
+
+// DefaultMaxFileSize is the per-file size limit for bundle inclusion.
+// A single vendored or minified file above this would blow the token
+// budget on its own. Overridable per node via budgets.max_file_size.
+const DefaultMaxFileSize int64 = 256 * 1024
+
+// SkippedFile records a file excluded from the bundle and why.
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// Bundle is the assembled context for one node.
+type Bundle struct {
+	NodeID    string            `json:"node_id"`
+	Files     map[string]string `json:"files"`
+	Contracts map[string]string `json:"contracts"`
+	Skipped   []SkippedFile     `json:"skipped,omitempty"`
+	// Truncated lists files dropped by Truncate to fit a token
+	// budget; Format and the prompt note them so the omission is
+	// visible to both the user and the model.
+	Truncated []string `json:"truncated,omitempty"`
+	Hash      string   `json:"hash"`
+}
+
+// skipDirs are directory names never included in bundles.
+var skipDirs = map[string]bool{
+	".git":         true,
+	".agentic":     true,
+	"node_modules": true,
+	"vendor":       true,
+	"dist":         true,
+	"build":        true,
+	"__pycache__":  true,
+}
+
+// binaryExts are file extensions that are unambiguously binary; they
+// are excluded without reading the file. Ambiguous extensions (like
+// .dat, which can hold text) are left to content sniffing.
+var binaryExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".ico": true,
+	".pdf": true, ".zip": true, ".tar": true, ".gz": true, ".bz2": true,
+	".exe": true, ".dll": true, ".so": true, ".dylib": true, ".a": true,
+	".o": true, ".bin": true, ".db": true, ".sqlite": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".eot": true,
+	".mp3": true, ".mp4": true, ".wav": true, ".avi": true, ".mov": true,
+}
+
+// isBinaryExt reports whether a file should be excluded by extension.
+func isBinaryExt(path string) bool {
+	return binaryExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// binarySniffLen is how many leading bytes are examined by content
+// sniffing.
+const binarySniffLen = 512
+
+// isBinaryContent sniffs the first 512 bytes and reports binary when
+// they contain a NUL byte or are not valid UTF-8, catching binaries
+// with missing or unusual extensions.
+func isBinaryContent(data []byte) bool {
+	sample := data
+	truncated := false
+	if len(sample) > binarySniffLen {
+		sample = sample[:binarySniffLen]
+		truncated = true
+	}
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	if truncated {
+		// Don't flag a multi-byte rune split at the sample boundary.
+		for i := 0; i < utf8.UTFMax-1 && len(sample) > 0 && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	return !utf8.Valid(sample)
+}
+
+// Build assembles the bundle for a node: all text files under the node's
+// path (relative paths as keys) plus the contracts of its direct
+// dependencies. Composite nodes carrying a nested GRAPH.manifest are
+// assembled from their subgraph via BuildComposite instead. Results are
+// cached under .agentic/cache keyed by the content hash of the node's
+// file set.
+func Build(g *graph.Graph, node *graph.Node) (*Bundle, error) {
+	if node.Type == graph.NodeTypeComposite {
+		root := filepath.Dir(g.Path)
+		manifest := filepath.Join(root, node.Path, graph.ManifestFileName)
+		if _, err := os.Stat(manifest); err == nil {
+			return BuildComposite(g, node)
+		}
+	}
+	b := &Bundle{
+		NodeID:    node.ID,
+		Files:     make(map[string]string),
+		Contracts: make(map[string]string),
+	}
+
+	root := filepath.Dir(g.Path)
+	nodeDir := filepath.Join(root, node.Path)
+
+	maxFileSize := DefaultMaxFileSize
+	if node.Meta != nil && node.Meta.Budgets.MaxFileSize > 0 {
+		maxFileSize = node.Meta.Budgets.MaxFileSize
+	}
+
+	// Collect the file list first, then read contents concurrently:
+	// large nodes are dominated by read time, not directory traversal.
+	type fileEntry struct {
+		abs string
+		rel string
+	}
+	var entries []fileEntry
+
+	err := filepath.WalkDir(nodeDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isBinaryExt(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(nodeDir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxFileSize {
+			b.Skipped = append(b.Skipped, SkippedFile{
+				Path:   rel,
+				Size:   info.Size(),
+				Reason: fmt.Sprintf("exceeds max file size (%d > %d bytes)", info.Size(), maxFileSize),
+			})
+			return nil
+		}
+		entries = append(entries, fileEntry{abs: path, rel: rel})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building bundle for %s: %w", node.ID, err)
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(entries) {
+		workers = len(entries)
+	}
+	if workers > 0 {
+		var (
+			mu       sync.Mutex
+			wg       sync.WaitGroup
+			firstErr error
+		)
+		work := make(chan fileEntry)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for entry := range work {
+					content, err := os.ReadFile(entry.abs)
+					mu.Lock()
+					switch {
+					case err != nil:
+						if firstErr == nil {
+							firstErr = fmt.Errorf("reading %s: %w", entry.abs, err)
+						}
+					case isBinaryContent(content):
+						b.Skipped = append(b.Skipped, SkippedFile{
+							Path:   entry.rel,
+							Size:   int64(len(content)),
+							Reason: "binary content",
+						})
+					default:
+						b.Files[entry.rel] = string(content)
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, entry := range entries {
+			work <- entry
+		}
+		close(work)
+		wg.Wait()
+		if firstErr != nil {
+			return nil, fmt.Errorf("building bundle for %s: %w", node.ID, firstErr)
+		}
+	}
+
+	// always_include pins override the extension and size filters: the
+	// listed files are read unconditionally and any skip record for
+	// them is dropped.
+	if node.Meta != nil {
+		for _, rel := range node.Meta.AlwaysInclude {
+			if _, ok := b.Files[rel]; ok {
+				continue
+			}
+			content, err := os.ReadFile(filepath.Join(nodeDir, rel))
+			if err != nil {
+				return nil, fmt.Errorf("always_include file %s for %s: %w", rel, node.ID, err)
+			}
+			b.Files[rel] = string(content)
+			for i, skipped := range b.Skipped {
+				if skipped.Path == rel {
+					b.Skipped = append(b.Skipped[:i], b.Skipped[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	// Concurrent reads append skips in completion order; sort for
+	// deterministic output.
+	sort.Slice(b.Skipped, func(i, j int) bool { return b.Skipped[i].Path < b.Skipped[j].Path })
+
+	for _, depID := range node.Dependencies {
+		dep, ok := g.Nodes[depID]
+		if !ok {
+			continue
+		}
+		if err := collectContracts(root, dep, b.Contracts); err != nil {
+			return nil, err
+		}
+	}
+
+	b.Hash = b.calculateHash()
+
+	if cached := loadCached(node.ID, b.Hash); cached != nil {
+		return cached, nil
+	}
+	saveCached(b)
+
+	return b, nil
+}
+
+// BuildComposite assembles a bundle for a composite node from the
+// nested GRAPH.manifest under its path: one summary per child node
+// (purpose, dependencies, invariants) plus every child's exported
+// contracts, rather than the subtree's raw files. This keeps the
+// graph-of-graphs recursion within budget — the model sees what the
+// children are and how to call them, not their implementations.
+func BuildComposite(g *graph.Graph, node *graph.Node) (*Bundle, error) {
+	root := filepath.Dir(g.Path)
+	nodeDir := filepath.Join(root, node.Path)
+	manifest := filepath.Join(nodeDir, graph.ManifestFileName)
+
+	sub, err := graph.Load(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("loading subgraph of %s: %w", node.ID, err)
+	}
+
+	b := &Bundle{
+		NodeID:    node.ID,
+		Files:     make(map[string]string),
+		Contracts: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("reading subgraph manifest of %s: %w", node.ID, err)
+	}
+	b.Files[graph.ManifestFileName] = string(data)
+
+	for _, id := range sub.Order {
+		child := sub.Nodes[id]
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "node: %s\npath: %s\ntype: %s\n", child.ID, child.Path, child.Type)
+		if len(child.Dependencies) > 0 {
+			fmt.Fprintf(&sb, "depends on: %s\n", strings.Join(child.Dependencies, ", "))
+		}
+		if child.Meta != nil {
+			if child.Meta.Purpose != "" {
+				fmt.Fprintf(&sb, "purpose: %s\n", child.Meta.Purpose)
+			}
+			for _, inv := range child.Meta.Invariants {
+				fmt.Fprintf(&sb, "invariant: %s\n", inv)
+			}
+		}
+		b.Files[child.ID+"/SUMMARY"] = sb.String()
+
+		if err := collectContracts(nodeDir, child, b.Contracts); err != nil {
+			return nil, err
+		}
+	}
+
+	// Contracts of the composite's own dependencies in the parent
+	// graph, same as a leaf bundle.
+	for _, depID := range node.Dependencies {
+		dep, ok := g.Nodes[depID]
+		if !ok {
+			continue
+		}
+		if err := collectContracts(root, dep, b.Contracts); err != nil {
+			return nil, err
+		}
+	}
+
+	b.Hash = b.calculateHash()
+	if cached := loadCached(node.ID, b.Hash); cached != nil {
+		return cached, nil
+	}
+	saveCached(b)
+	return b, nil
+}
+
+// collectContracts reads a dependency's CONTRACTS directory into the
+// contracts map, keyed as depID/filename.
+func collectContracts(root string, dep *graph.Node, contracts map[string]string) error {
+	dir := filepath.Join(root, dep.Path, ContractsDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading contracts of %s: %w", dep.ID, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || isBinaryExt(entry.Name()) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("reading contract %s: %w", entry.Name(), err)
+		}
+		contracts[dep.ID+"/"+entry.Name()] = string(content)
+	}
+	return nil
+}
+
+// calculateHash computes a reproducible hash over the bundle content.
+// Paths are sorted so the hash is independent of map iteration order.
+func (b *Bundle) calculateHash() string {
+	h := sha256.New()
+	paths := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s\x00%s\x00", path, b.Files[path])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Overlay applies pending (staged but unapplied) file contents on top
+// of the on-disk bundle content, so iterative runs see the work already
+// staged for the node instead of conflicting with it. The hash is
+// recomputed over the overlaid content.
+func (b *Bundle) Overlay(files map[string]string) {
+	if len(files) == 0 {
+		return
+	}
+	for path, content := range files {
+		b.Files[path] = content
+	}
+	b.Hash = b.calculateHash()
+}
+
+// EstimateTokens estimates the total prompt tokens this bundle will
+// consume, including contracts and metadata overhead.
+func (b *Bundle) EstimateTokens() int {
+	return token.EstimateMap(b.Files) + token.EstimateMap(b.Contracts) + metadataOverhead
+}
+
+// Truncate drops files until the bundle's estimate fits within budget
+// tokens, preferring test files and then the largest files — the same
+// low-information-density candidates the split heuristics target.
+// Dropped paths are recorded in Truncated and returned; pinned
+// always_include files are handled upstream and not special-cased
+// here. Returns nil when the bundle already fits.
+func (b *Bundle) Truncate(budget int) []string {
+	if b.EstimateTokens() <= budget {
+		return nil
+	}
+
+	paths := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		ti, tj := strings.HasSuffix(paths[i], "_test.go"), strings.HasSuffix(paths[j], "_test.go")
+		if ti != tj {
+			return ti
+		}
+		if li, lj := len(b.Files[paths[i]]), len(b.Files[paths[j]]); li != lj {
+			return li > lj
+		}
+		return paths[i] < paths[j]
+	})
+
+	var dropped []string
+	for _, path := range paths {
+		if b.EstimateTokens() <= budget {
+			break
+		}
+		delete(b.Files, path)
+		dropped = append(dropped, path)
+	}
+	sort.Strings(dropped)
+	b.Truncated = append(b.Truncated, dropped...)
+	return dropped
+}
+
+// EstimateSourceTokens estimates tokens for the node's own files only,
+// excluding dependency contracts and framing overhead. Used by
+// budget_scope: source policies.
+func (b *Bundle) EstimateSourceTokens() int {
+	return token.EstimateMap(b.Files)
+}
+
+// Format renders the bundle into prompt form with file delimiters.
+func (b *Bundle) Format() string {
+	var sb strings.Builder
+
+	paths := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&sb, "=== FILE: %s ===\n%s\n=== END FILE ===\n\n", path, b.Files[path])
+	}
+
+	if len(b.Truncated) > 0 {
+		fmt.Fprintf(&sb, "[truncated: omitted %d file(s) to fit the token budget: %s]\n\n",
+			len(b.Truncated), strings.Join(b.Truncated, ", "))
+	}
+
+	if len(b.Contracts) > 0 {
+		sb.WriteString("--- Dependency Contracts ---\n\n")
+		names := make([]string, 0, len(b.Contracts))
+		for name := range b.Contracts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&sb, "=== CONTRACT: %s ===\n%s\n=== END CONTRACT ===\n\n", name, b.Contracts[name])
+		}
+	}
+
+	return sb.String()
+}
+
+// cachePath returns the cache file for a node.
+func cachePath(nodeID string) string {
+	return filepath.Join(cacheDir(), nodeID+".json")
+}
+
+// loadCached returns the cached bundle for a node if its hash still
+// matches the freshly computed one, else nil.
+func loadCached(nodeID, hash string) *Bundle {
+	data, err := os.ReadFile(cachePath(nodeID))
+	if err != nil {
+		return nil
+	}
+	var cached Bundle
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil
+	}
+	if cached.Hash != hash {
+		return nil
+	}
+	return &cached
+}
+
+// saveCached writes the bundle to the cache, best-effort.
+func saveCached(b *Bundle) {
+	if err := os.MkdirAll(cacheDir(), 0755); err != nil {
+		return
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(cachePath(b.NodeID), data, 0644)
+}
+
+// InvalidateCache removes the cached bundle for a node.
+func InvalidateCache(nodeID string) {
+	_ = os.Remove(cachePath(nodeID))
+}
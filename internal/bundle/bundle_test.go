@@ -0,0 +1,139 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aid/agentic/internal/graph"
+)
+
+// benchProject creates a project with one node containing n files and
+// returns the graph and node, with the working directory switched to
+// the project root so the bundle cache stays inside it.
+func benchProject(tb testing.TB, n int) (*graph.Graph, *graph.Node) {
+	tb.Helper()
+	root := tb.TempDir()
+	nodeDir := filepath.Join(root, "nodes", "big")
+	if err := os.MkdirAll(nodeDir, 0755); err != nil {
+		tb.Fatal(err)
+	}
+	content := strings.Repeat("package big\n\n// filler line for benchmark content\n", 40)
+	for i := 0; i < n; i++ {
+		path := filepath.Join(nodeDir, fmt.Sprintf("file_%03d.go", i))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	prev, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.Chdir(prev) })
+
+	node := &graph.Node{ID: "big", Type: graph.NodeTypeLeaf, Path: "nodes/big"}
+	g := &graph.Graph{
+		Path:  filepath.Join(root, graph.ManifestFileName),
+		Nodes: map[string]*graph.Node{"big": node},
+		Order: []string{"big"},
+	}
+	return g, node
+}
+
+func TestBuildReadsAllFiles(t *testing.T) {
+	g, node := benchProject(t, 50)
+	b, err := Build(g, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Files) != 50 {
+		t.Fatalf("got %d files, want 50", len(b.Files))
+	}
+	if b.Hash == "" {
+		t.Fatal("bundle hash not computed")
+	}
+
+	// The hash must be reproducible across builds.
+	InvalidateCache(node.ID)
+	b2, err := Build(g, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b2.Hash != b.Hash {
+		t.Fatalf("hash not reproducible: %s vs %s", b.Hash, b2.Hash)
+	}
+}
+
+func TestBuildSniffsBinaryContent(t *testing.T) {
+	g, node := benchProject(t, 1)
+	nodeDir := filepath.Join("nodes", "big")
+
+	// A crafted binary blob with no extension must be skipped.
+	blob := append([]byte{0x7f, 'E', 'L', 'F', 0x00, 0x01}, make([]byte, 64)...)
+	if err := os.WriteFile(filepath.Join(nodeDir, "blob"), blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A UTF-8 text file with an unusual extension must be included.
+	if err := os.WriteFile(filepath.Join(nodeDir, "notes.dat"), []byte("plain text, даже unicode\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Build(g, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Files["blob"]; ok {
+		t.Error("binary blob was included in bundle")
+	}
+	found := false
+	for _, s := range b.Skipped {
+		if s.Path == "blob" && s.Reason == "binary content" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("binary blob not recorded as skipped: %+v", b.Skipped)
+	}
+	if _, ok := b.Files["notes.dat"]; !ok {
+		t.Error("UTF-8 .dat file was not included in bundle")
+	}
+}
+
+func TestIsBinaryContent(t *testing.T) {
+	if isBinaryContent([]byte("hello world\n")) {
+		t.Error("plain ASCII flagged as binary")
+	}
+	if isBinaryContent([]byte("héllo wörld ünïcode\n")) {
+		t.Error("valid UTF-8 flagged as binary")
+	}
+	if !isBinaryContent([]byte{'a', 0x00, 'b'}) {
+		t.Error("NUL byte not flagged as binary")
+	}
+	if !isBinaryContent([]byte{0xff, 0xfe, 0xfd}) {
+		t.Error("invalid UTF-8 not flagged as binary")
+	}
+	// A multi-byte rune split at the sniff boundary must not flag a
+	// large valid UTF-8 file.
+	big := append(bytes.Repeat([]byte{'a'}, binarySniffLen-1), []byte("é more text")...)
+	if isBinaryContent(big) {
+		t.Error("rune split at sniff boundary flagged as binary")
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	g, node := benchProject(b, 400)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		InvalidateCache(node.ID)
+		if _, err := Build(g, node); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,2688 @@
+// Package cli defines the agentic command tree and the interactive
+// REPL. Commands are thin wrappers over the graph, bundle, brain,
+// policy, and workspace packages.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aid/agentic/internal/brain"
+	"github.com/aid/agentic/internal/bundle"
+	"github.com/aid/agentic/internal/config"
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/policy"
+	"github.com/aid/agentic/internal/token"
+	"github.com/aid/agentic/internal/workspace"
+)
+
+var (
+	cfgFile        string
+	flagNode       string
+	flagVerbose    bool
+	flagJSON       bool
+	flagParallel   bool
+	flagDiscover   bool
+	flagYes        bool
+	flagSkipChecks bool
+	flagFormat     string
+	flagDryRun     bool
+	flagShowLevels bool
+	flagTag        string
+
+	flagDiscoverOverwrite bool
+	flagConfirmEach       bool
+	flagDescribe          bool
+	flagRecheck           bool
+	flagNoAtomic          bool
+	flagReviewConfirmed   bool
+	flagForce             bool
+	flagBrain             string
+	flagDryApply          bool
+	flagCommit            bool
+	flagSuggest           bool
+	flagSplitApply        bool
+	flagSort              string
+	flagTokensFormat      string
+	flagDirty             bool
+	flagDependentsOf      string
+	flagMaxParallel       int
+	flagKeepGoing         bool
+	flagOutputDir         string
+	flagResetDirty        bool
+	flagNoLog             bool
+	flagInitContracts     bool
+	flagTruncate          bool
+	flagRunFormat         string
+	flagFixHeaders        bool
+	flagActualTokens      int
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "agentic",
+	Short: "Orchestrate AI tasks over a graph of focused code nodes",
+	Long: `agentic breaks a codebase into focused nodes with defined boundaries,
+dependencies, and constraints, and runs AI tasks on each piece within
+those boundaries.
+
+Exit codes: 0 success, 1 generic error, 2 policy violation,
+3 token budget exceeded, 4 node check failure.`,
+	SilenceUsage:      true,
+	PersistentPreRunE: loadConfig,
+}
+
+// fileCfg is the project config loaded before every command; flags and
+// environment variables take precedence over it.
+var fileCfg = &config.FileConfig{}
+
+// loadConfig reads --config (or the default path) and applies the
+// settings that no flag or environment variable overrides.
+func loadConfig(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadFile(cfgFile)
+	if err != nil {
+		return err
+	}
+	fileCfg = cfg
+	brain.Verbose = flagVerbose
+
+	if cfg.Parallel && !cmd.Flags().Changed("parallel") {
+		flagParallel = true
+	}
+	if cfg.SkipChecks && !cmd.Flags().Changed("skip-checks") {
+		flagSkipChecks = true
+	}
+	if cfg.MaxParallel > 0 && !cmd.Flags().Changed("max-parallel") {
+		flagMaxParallel = cfg.MaxParallel
+	}
+	if cfg.TokenBudget > 0 {
+		b := token.DefaultBudgets["default"]
+		b.MaxContext = cfg.TokenBudget
+		token.DefaultBudgets["default"] = b
+	}
+	if cfg.CheckTimeout != "" {
+		d, err := time.ParseDuration(cfg.CheckTimeout)
+		if err != nil {
+			return fmt.Errorf("config check_timeout %q: %w", cfg.CheckTimeout, err)
+		}
+		policy.SetDefaultCheckTimeout(d)
+	}
+	if cfg.Estimator != "" {
+		if err := token.SetEstimator(cfg.Estimator); err != nil {
+			return fmt.Errorf("config estimator: %w", err)
+		}
+	}
+	for model, spec := range cfg.Budgets {
+		token.SetBudget(model, spec.MaxContext, spec.ReservedOutput)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default .agentic/config.yaml)")
+	rootCmd.PersistentFlags().BoolVarP(&flagVerbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().BoolVar(&flagJSON, "json", false, "emit JSON instead of human-readable output (status, diff, graph, plan)")
+
+	runCmd.Flags().StringVarP(&flagNode, "node", "n", "", "target a specific node")
+	runCmd.Flags().BoolVarP(&flagParallel, "parallel", "P", false, "process independent nodes in parallel")
+	runCmd.Flags().StringVar(&flagTag, "tag", "", "limit to nodes carrying a tag")
+	runCmd.Flags().StringVar(&flagBrain, "brain", "", "brain adapter to use (overrides AGENTIC_BRAIN)")
+	runCmd.Flags().BoolVar(&flagDryApply, "dry-apply", false, "evaluate policies and preview the apply without saving state or writing files")
+	runCmd.Flags().BoolVar(&flagDirty, "dirty", false, "run only on the workspace's dirty nodes")
+	runCmd.Flags().StringVar(&flagDependentsOf, "dependents-of", "", "run on the transitive dependents of this node")
+	runCmd.Flags().IntVar(&flagMaxParallel, "max-parallel", runtime.GOMAXPROCS(0), "maximum concurrent brain calls with --parallel")
+	runCmd.Flags().BoolVar(&flagKeepGoing, "keep-going", false, "with --parallel, continue past node failures and report at the end")
+	runCmd.Flags().BoolVar(&flagNoLog, "no-log", false, "do not append brain calls to the transcript log")
+	runCmd.Flags().BoolVar(&flagTruncate, "truncate", false, "when a bundle exceeds the token budget, drop tests and the largest files instead of failing")
+	runCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "build the bundle and prompt and print them, but do not call the brain")
+	runCmd.Flags().StringVar(&flagRunFormat, "format", "table", "end-of-run summary format: table or json")
+	planCmd.Flags().StringVar(&flagTag, "tag", "", "limit to nodes carrying a tag")
+	planCmd.Flags().StringVarP(&flagNode, "node", "n", "", "scope the plan to this node and its dependents")
+	planCmd.Flags().BoolVar(&flagDirty, "dirty", false, "scope the plan to the workspace's dirty nodes")
+	graphCmd.Flags().StringVar(&flagTag, "tag", "", "list only nodes carrying a tag")
+
+	planCmd.Flags().BoolVarP(&flagParallel, "parallel", "P", false, "plan as a parallel run")
+	planCmd.Flags().BoolVar(&flagShowLevels, "show-levels", false, "show the dependency levels a parallel run would use")
+
+	graphCmd.Flags().BoolVar(&flagDiscover, "discover", false, "discover nodes and merge into GRAPH.manifest")
+	graphCmd.Flags().BoolVar(&flagDiscoverOverwrite, "discover-overwrite", false, "discover nodes and rewrite GRAPH.manifest from scratch")
+	graphCmd.Flags().StringVar(&flagFormat, "format", "tree", "output format: tree, dot, or json")
+	graphCmd.Flags().BoolVar(&flagDescribe, "describe", false, "show each node's purpose in the tree")
+
+	applyCmd.Flags().BoolVarP(&flagYes, "yes", "y", false, "apply without confirmation")
+	applyCmd.Flags().BoolVar(&flagSkipChecks, "skip-checks", false, "skip node checks after apply")
+	applyCmd.Flags().BoolVar(&flagConfirmEach, "confirm-each", false, "apply node by node, pausing for review between nodes")
+	applyCmd.Flags().BoolVar(&flagRecheck, "recheck", false, "re-run the build and checks for the last apply without re-writing files")
+	applyCmd.Flags().BoolVar(&flagNoAtomic, "no-atomic", false, "leave already-written files in place if a write fails partway")
+	applyCmd.Flags().BoolVar(&flagReviewConfirmed, "review-confirmed", false, "acknowledge review of nodes marked review_required")
+	applyCmd.Flags().BoolVar(&flagForce, "force", false, "apply even if files changed on disk after staging")
+	applyCmd.Flags().BoolVar(&flagCommit, "commit", false, "git-commit the written files after a successful apply")
+	applyCmd.Flags().BoolVar(&flagFixHeaders, "fix-headers", false, "prepend missing require_header license headers before applying")
+	applyCmd.Flags().StringVar(&flagOutputDir, "output-dir", "", "write files under this mirror directory instead of in place")
+	resetCmd.Flags().BoolVarP(&flagYes, "yes", "y", false, "discard without confirmation")
+	resetCmd.Flags().BoolVar(&flagResetDirty, "dirty", false, "also clear dirty markers")
+
+	undoCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "show what would be reverted without changing anything")
+	rollbackCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "show what would be reverted without changing anything")
+	rollbackCmd.Flags().BoolVarP(&flagYes, "yes", "y", false, "skip confirmation")
+
+	diffCmd.Flags().StringVarP(&flagNode, "node", "n", "", "limit to a specific node")
+	splitCmd.Flags().StringVarP(&flagNode, "node", "n", "", "node to analyze")
+
+	tokenCalibrateCmd.Flags().IntVar(&flagActualTokens, "actual-tokens", 0, "token count a model reported for this node's bundle")
+	tokensCmd.Flags().StringVar(&flagSort, "sort", "graph", "row order: graph or tokens")
+	tokensCmd.Flags().StringVar(&flagTokensFormat, "format", "table", "output format: table or json")
+
+	graphCmd.AddCommand(graphDiffCmd)
+	tokenCmd.AddCommand(tokenCalibrateCmd)
+	splitCmd.Flags().BoolVar(&flagSuggest, "suggest", false, "print the proposed file-to-child grouping without splitting")
+	splitCmd.Flags().BoolVar(&flagSplitApply, "apply", false, "create the proposed child nodes: move files, write meta stubs, rewrite the manifest")
+
+	contractsUpdateCmd.Flags().BoolVar(&flagForce, "force", false, "rehash every node even if its sources look unmodified")
+	contractsCmd.AddCommand(contractsUpdateCmd, contractsCheckCmd, contractsExportCmd)
+	initCmd.Flags().BoolVar(&flagInitContracts, "contracts", false, "also export each node's API into its CONTRACTS/ directory")
+
+	snapshotCmd.AddCommand(snapshotSaveCmd, snapshotRestoreCmd, snapshotListCmd)
+
+	rootCmd.AddCommand(initCmd, graphCmd, runCmd, planCmd, statusCmd,
+		diffCmd, unstageCmd, resetCmd, applyCmd, replayCmd, undoCmd, redoCmd, rollbackCmd, splitCmd, mergeCmd, apiCmd, contractsCmd, checkCmd, watchCmd, selfTestCmd, tokenCmd, tokensCmd, snapshotCmd, replCmd)
+}
+
+// Execute runs the root command, translating typed errors into the
+// exit codes documented in the root help.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize an agentic project",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runInit()
+	},
+}
+
+func runInit() error {
+	if err := os.MkdirAll(workspace.DataDir(), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(graph.ManifestFileName); os.IsNotExist(err) {
+		fmt.Println("No GRAPH.manifest found, discovering nodes...")
+		return discoverManifest()
+	}
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	if problems := g.Verify(); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println("  -", p)
+		}
+		return fmt.Errorf("manifest verification found %d problem(s)", len(problems))
+	}
+	fmt.Printf("Initialized. Loaded %d nodes from %s\n", len(g.Nodes), graph.ManifestFileName)
+	if flagInitContracts {
+		return runContractsExport("")
+	}
+	return nil
+}
+
+// discoverManifest scans the tree for nodes and updates GRAPH.manifest.
+// By default an existing manifest is merged into, preserving
+// hand-maintained token caps, versions, and tags; --discover-overwrite
+// rewrites it from scratch.
+func discoverManifest() error {
+	g, err := graph.Discover(".")
+	if err != nil {
+		return err
+	}
+	if len(g.Nodes) == 0 {
+		return fmt.Errorf("no nodes found (directories containing %s)", graph.MetaFileName)
+	}
+
+	if !flagDiscoverOverwrite {
+		if existing, err := graph.Load(graph.ManifestFileName); err == nil {
+			merged, err := graph.MergeDiscovered(existing, g)
+			if err != nil {
+				return err
+			}
+			diff := graph.DiffGraphs(existing, merged)
+			if err := merged.Save(); err != nil {
+				return err
+			}
+			if diff.Empty() {
+				fmt.Printf("Manifest up to date: %d nodes.\n", len(merged.Nodes))
+				return nil
+			}
+			for _, id := range diff.Added {
+				fmt.Printf("+ added node %s\n", id)
+			}
+			for _, id := range diff.Removed {
+				fmt.Printf("- removed node %s\n", id)
+			}
+			for _, nd := range diff.Changed {
+				fmt.Printf("~ updated node %s\n", nd.ID)
+			}
+			fmt.Printf("Merged %d nodes into %s\n", len(merged.Nodes), graph.ManifestFileName)
+			return nil
+		}
+	}
+
+	if err := g.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Discovered %d nodes, wrote %s\n", len(g.Nodes), graph.ManifestFileName)
+	return nil
+}
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Show or rediscover the node graph",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if flagDiscover || flagDiscoverOverwrite {
+			return discoverManifest()
+		}
+		return runGraph()
+	},
+}
+
+var graphDiffCmd = &cobra.Command{
+	Use:   "diff <old.manifest> <new.manifest>",
+	Short: "Compare two manifests",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runGraphDiff(args[0], args[1])
+	},
+}
+
+// runGraphDiff loads two manifests and reports what changed between
+// them, for reviewing auto-discovery output before committing it.
+func runGraphDiff(oldPath, newPath string) error {
+	oldGraph, err := graph.Load(oldPath)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", oldPath, err)
+	}
+	newGraph, err := graph.Load(newPath)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", newPath, err)
+	}
+	diff := graph.DiffGraphs(oldGraph, newGraph)
+
+	if flagFormat == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if diff.Empty() {
+		fmt.Println("No differences.")
+		return nil
+	}
+	for _, id := range diff.Added {
+		fmt.Printf("+ added node %s\n", id)
+	}
+	for _, id := range diff.Removed {
+		fmt.Printf("- removed node %s\n", id)
+	}
+	for _, nd := range diff.Changed {
+		fmt.Printf("~ %s:\n", nd.ID)
+		if nd.OldDeps != nil || nd.NewDeps != nil {
+			fmt.Printf("    deps: [%s] -> [%s]\n",
+				strings.Join(nd.OldDeps, ","), strings.Join(nd.NewDeps, ","))
+		}
+		if nd.OldToks != nd.NewToks {
+			fmt.Printf("    toks: %d -> %d\n", nd.OldToks, nd.NewToks)
+		}
+		if nd.OldVer != nd.NewVer {
+			fmt.Printf("    ver:  %d -> %d\n", nd.OldVer, nd.NewVer)
+		}
+	}
+	return nil
+}
+
+// runGraph loads and prints the graph in the requested format.
+func runGraph() error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	if flagJSON {
+		flagFormat = "json"
+	}
+	if flagTag != "" {
+		found := false
+		for _, id := range g.Order {
+			node := g.Nodes[id]
+			if !node.HasTag(flagTag) {
+				continue
+			}
+			found = true
+			fmt.Printf("%s (toks=%d) tags=[%s]\n", node.ID, node.TokenCap, strings.Join(node.Tags, ","))
+		}
+		if !found {
+			return fmt.Errorf("no nodes carry tag %q", flagTag)
+		}
+		return nil
+	}
+	switch flagFormat {
+	case "dot":
+		fmt.Print(g.ToDOT())
+	case "json":
+		data, err := g.ToJSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "", "tree":
+		fmt.Printf("Graph: %d nodes\n\n", len(g.Nodes))
+		g.Print(flagDescribe)
+		if orphans := g.FindOrphans(); len(orphans) > 0 {
+			fmt.Println("\nWarning: orphan nodes (no dependencies, no dependents):")
+			for _, node := range orphans {
+				fmt.Printf("  %s\n", node.ID)
+			}
+		}
+		if missing := g.FindMissingPaths(); len(missing) > 0 {
+			fmt.Println("\nWarning: nodes whose path no longer exists:")
+			for _, node := range missing {
+				fmt.Printf("  %s (%s)\n", node.ID, node.Path)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %q (want tree, dot, or json)", flagFormat)
+	}
+	return nil
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run <request>",
+	Short: "Run an AI task on one or more nodes",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTask(strings.Join(args, " "), flagNode, flagParallel)
+	},
+}
+
+// runTask runs a request against the selected nodes, sequentially or
+// in parallel dependency levels, and prints an end-of-run summary of
+// what each node did.
+func runTask(request, node string, parallel bool) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := selectNodes(g, node)
+	if err != nil {
+		return err
+	}
+
+	activeReport = &runReport{}
+	defer func() { activeReport = nil }()
+
+	var runErr error
+	if parallel {
+		runErr = runTasksParallel(g, ws, nodes, request)
+	} else {
+		for _, n := range nodes {
+			if runErr = runSingleNodeTask(g, ws, n, request); runErr != nil {
+				break
+			}
+		}
+	}
+
+	activeReport.print()
+	if runErr != nil {
+		return runErr
+	}
+	if flagDryApply {
+		return dryApplySummary(g, ws)
+	}
+	return nil
+}
+
+// runReportEntry is one node's outcome in the end-of-run summary.
+type runReportEntry struct {
+	Node    string  `json:"node"`
+	Status  string  `json:"status"` // staged, no-changes, out-of-scope, dry-run, declined, over-budget, failed
+	Files   int     `json:"files,omitempty"`
+	Deletes int     `json:"deletes,omitempty"`
+	Tokens  int     `json:"tokens,omitempty"`
+	Cost    float64 `json:"cost,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// runReport collects per-node outcomes across a run; parallel workers
+// record concurrently.
+type runReport struct {
+	mu      sync.Mutex
+	entries []runReportEntry
+}
+
+// activeReport is the collector for the run in progress; nil outside
+// runTask (e.g. REPL one-offs), in which case records are dropped.
+var activeReport *runReport
+
+// recordRun adds one node outcome to the active run report, if any.
+func recordRun(e runReportEntry) {
+	r := activeReport
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// print renders the end-of-run summary, as a table or as JSON under
+// --format=json. Nothing is printed for an empty report.
+func (r *runReport) print() {
+	r.mu.Lock()
+	entries := append([]runReportEntry(nil), r.entries...)
+	r.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Node < entries[j].Node })
+
+	if flagRunFormat == "json" {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err == nil {
+			fmt.Println(string(data))
+		}
+		return
+	}
+
+	var files, tokens int
+	var cost float64
+	for _, e := range entries {
+		files += e.Files + e.Deletes
+		tokens += e.Tokens
+		cost += e.Cost
+	}
+	fmt.Printf("\nRun summary: %d node(s), %d file(s) staged, ~%d tokens sent", len(entries), files, tokens)
+	if cost > 0 {
+		fmt.Printf(", $%.4f", cost)
+	}
+	fmt.Println()
+	for _, e := range entries {
+		switch e.Status {
+		case "staged":
+			fmt.Printf("  %-12s %s  %d file(s)", e.Status, e.Node, e.Files)
+			if e.Deletes > 0 {
+				fmt.Printf(", %d deletion(s)", e.Deletes)
+			}
+			fmt.Printf(", ~%d tokens\n", e.Tokens)
+		case "no-changes", "dry-run":
+			fmt.Printf("  %-12s %s\n", e.Status, e.Node)
+		default:
+			fmt.Printf("  %-12s %s: %s\n", e.Status, e.Node, e.Error)
+		}
+	}
+}
+
+// selectedBrain resolves the adapter for this run: --brain wins over
+// AGENTIC_BRAIN, which wins over the config file's brain setting.
+func selectedBrain() string {
+	if flagBrain != "" {
+		return flagBrain
+	}
+	if os.Getenv("AGENTIC_BRAIN") == "" && fileCfg.Brain != "" {
+		return fileCfg.Brain
+	}
+	return brain.DefaultAdapterName()
+}
+
+// dryApplySummary evaluates policies and previews the apply for the
+// in-memory staged changes of a --dry-apply run. Nothing is written:
+// staged state is never saved and no project files are touched, so the
+// whole pipeline can be verified in CI with the mock brain.
+func dryApplySummary(g *graph.Graph, ws *workspace.Workspace) error {
+	staged := ws.StagedNodes()
+	if len(staged) == 0 {
+		fmt.Println("Dry apply: nothing staged.")
+		return nil
+	}
+
+	violated := false
+	for _, nodeID := range staged {
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			continue
+		}
+		b, err := bundle.Build(g, node)
+		if err != nil {
+			return err
+		}
+		for _, v := range policy.Evaluate(node, b, ws.StagedChanges[nodeID]) {
+			fmt.Println(v)
+			if v.Severity == policy.SeverityError {
+				violated = true
+			}
+		}
+	}
+
+	ws.PrintDiff("")
+
+	total := 0
+	for _, nodeID := range staged {
+		total += len(ws.StagedChanges[nodeID])
+	}
+	if violated {
+		return exitErrf(ExitPolicy, "dry apply: policy violations above would block a real apply")
+	}
+	fmt.Printf("Dry apply: would write %d file(s) across %d node(s). No files or state were modified.\n",
+		total, len(staged))
+	return nil
+}
+
+// selectNodes resolves the target node set: a named node, the dirty
+// or dependent subsets when --dirty/--dependents-of are given, or all
+// leaf nodes in topological order, optionally restricted by --tag.
+func selectNodes(g *graph.Graph, node string) ([]*graph.Node, error) {
+	if node != "" {
+		n, ok := g.Nodes[node]
+		if !ok {
+			return nil, fmt.Errorf("node %q not found in graph", node)
+		}
+		return []*graph.Node{n}, nil
+	}
+	if flagDirty || flagDependentsOf != "" {
+		want := make(map[string]bool)
+		if flagDirty {
+			ws, err := workspace.Load()
+			if err != nil {
+				return nil, err
+			}
+			for id := range ws.DirtyNodes {
+				want[id] = true
+			}
+		}
+		if flagDependentsOf != "" {
+			if _, ok := g.Nodes[flagDependentsOf]; !ok {
+				return nil, fmt.Errorf("node %q not found in graph", flagDependentsOf)
+			}
+			for _, dep := range g.GetReverseDeps(flagDependentsOf) {
+				want[dep.ID] = true
+			}
+		}
+		var nodes []*graph.Node
+		for _, id := range g.Order {
+			n := g.Nodes[id]
+			if !want[id] || n.Type != graph.NodeTypeLeaf {
+				continue
+			}
+			if flagTag != "" && !n.HasTag(flagTag) {
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("no nodes match the selection")
+		}
+		return nodes, nil
+	}
+	var nodes []*graph.Node
+	for _, id := range g.Order {
+		n := g.Nodes[id]
+		if n.Type != graph.NodeTypeLeaf {
+			continue
+		}
+		if flagTag != "" && !n.HasTag(flagTag) {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if flagTag != "" && len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes carry tag %q", flagTag)
+	}
+	return nodes, nil
+}
+
+// runSingleNodeTask runs the full pipeline for one node: bundle,
+// budget check, prompt, brain call, staging.
+func runSingleNodeTask(g *graph.Graph, ws *workspace.Workspace, node *graph.Node, request string) error {
+	fmt.Printf("[%s] building bundle...\n", node.ID)
+	b, err := bundle.Build(g, node)
+	if err != nil {
+		return err
+	}
+
+	// Overlay pending staged changes so repeated runs before an apply
+	// build on the staged work rather than the stale on-disk files.
+	if staged := ws.StagedChanges[node.ID]; len(staged) > 0 {
+		b.Overlay(staged)
+		fmt.Printf("[%s] including %d staged file(s) in context\n", node.ID, len(staged))
+	}
+
+	budget := token.GetBudget("default")
+	estimate := b.EstimateTokens()
+	if estimate > budget.Available() {
+		if !flagTruncate {
+			err := exitErrf(ExitBudget, "node %s bundle is ~%d tokens, exceeds available budget %d (re-run with --truncate to proceed with a reduced bundle)",
+				node.ID, estimate, budget.Available())
+			recordRun(runReportEntry{Node: node.ID, Status: "over-budget", Error: err.Error()})
+			return err
+		}
+		dropped := b.Truncate(budget.Available())
+		fmt.Printf("[%s] bundle was ~%d tokens, over budget %d; omitted %d file(s): %s\n",
+			node.ID, estimate, budget.Available(), len(dropped), strings.Join(dropped, ", "))
+		if still := b.EstimateTokens(); still > budget.Available() {
+			err := exitErrf(ExitBudget, "node %s bundle is still ~%d tokens after truncation, exceeds available budget %d",
+				node.ID, still, budget.Available())
+			recordRun(runReportEntry{Node: node.ID, Status: "over-budget", Error: err.Error()})
+			return err
+		}
+	}
+
+	prompt := brain.BuildPrompt(node, b, request)
+	if flagVerbose {
+		verboseBundle(b)
+		fmt.Printf("[%s] prompt is ~%d tokens\n", node.ID, token.EstimateString(prompt))
+	}
+
+	promptTokens := token.EstimateString(prompt)
+	if flagDryRun {
+		fmt.Printf("[%s] dry run: prompt is ~%d tokens, would call %s\n",
+			node.ID, promptTokens, selectedBrain())
+		fmt.Println(prompt)
+		recordRun(runReportEntry{Node: node.ID, Status: "dry-run", Tokens: promptTokens})
+		return nil
+	}
+
+	fmt.Printf("[%s] calling %s...\n", node.ID, selectedBrain())
+	resp, err := brain.CallInDir(selectedBrain(), prompt, node.Path)
+	logTranscript(node.ID, request, prompt, resp, err)
+	if err != nil {
+		if errors.Is(err, brain.ErrBrainRefused) {
+			fmt.Printf("[%s] Brain declined the task:\n", node.ID)
+			if resp != nil && resp.Message != "" {
+				fmt.Println(resp.Message)
+			}
+			recordRun(runReportEntry{Node: node.ID, Status: "declined", Tokens: promptTokens, Error: err.Error()})
+			return err
+		}
+		recordRun(runReportEntry{Node: node.ID, Status: "failed", Tokens: promptTokens, Error: err.Error()})
+		return fmt.Errorf("brain call for %s: %w", node.ID, err)
+	}
+
+	if len(resp.Files) == 0 && len(resp.Deletes) == 0 {
+		fmt.Printf("[%s] No file changes\n", node.ID)
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+		recordRun(runReportEntry{Node: node.ID, Status: "no-changes", Tokens: promptTokens, Cost: resp.Cost})
+		return nil
+	}
+
+	// Drop out-of-scope files before staging so violations never reach
+	// apply; the prompt already told the model the boundaries.
+	for path := range resp.Files {
+		if !policy.InScope(node, path) {
+			fmt.Printf("[%s] warning: dropping %s: outside allowed paths\n", node.ID, path)
+			delete(resp.Files, path)
+		}
+	}
+	deletes := resp.Deletes[:0]
+	for _, path := range resp.Deletes {
+		if !policy.InScope(node, path) {
+			fmt.Printf("[%s] warning: ignoring deletion of %s: outside allowed paths\n", node.ID, path)
+			continue
+		}
+		deletes = append(deletes, path)
+	}
+	resp.Deletes = deletes
+	if len(resp.Files) == 0 && len(resp.Deletes) == 0 {
+		fmt.Printf("[%s] all proposed changes were out of scope; nothing staged\n", node.ID)
+		recordRun(runReportEntry{Node: node.ID, Status: "out-of-scope", Tokens: promptTokens, Cost: resp.Cost,
+			Error: "all proposed changes were outside allowed paths"})
+		return nil
+	}
+
+	for _, warning := range brain.DetectStubs(resp.Files) {
+		fmt.Printf("[%s] warning: possible stub: %s\n", node.ID, warning)
+	}
+
+	// The model emits node-relative paths (that is what InScope just
+	// matched); staged keys are repo-relative so that drift hashes,
+	// diff, and apply all read them straight from the working tree.
+	staged := make(map[string]string, len(resp.Files)+len(resp.Deletes))
+	for path, content := range resp.Files {
+		staged[filepath.Join(node.Path, path)] = content
+	}
+	for _, path := range resp.Deletes {
+		staged[filepath.Join(node.Path, path)] = workspace.DeletedFile
+	}
+
+	ws.StageFiles(node.ID, staged)
+	ws.SetStagedInfo(node.ID, workspace.StagedInfo{
+		Request: request,
+		Message: resp.Message,
+		Cost:    resp.Cost,
+	})
+	if !flagDryApply {
+		if err := ws.Save(); err != nil {
+			return err
+		}
+	}
+	if len(resp.Deletes) > 0 {
+		fmt.Printf("[%s] Changes staged: %d file(s), %d deletion(s). Review with 'agentic diff'.\n",
+			node.ID, len(resp.Files), len(resp.Deletes))
+	} else {
+		fmt.Printf("[%s] Changes staged: %d file(s). Review with 'agentic diff'.\n", node.ID, len(resp.Files))
+	}
+	recordRun(runReportEntry{Node: node.ID, Status: "staged", Files: len(resp.Files),
+		Deletes: len(resp.Deletes), Tokens: promptTokens, Cost: resp.Cost})
+	return nil
+}
+
+// transcriptEntry is one brain call record in a transcript JSONL file.
+type transcriptEntry struct {
+	Time     time.Time `json:"time"`
+	Node     string    `json:"node"`
+	Brain    string    `json:"brain"`
+	Request  string    `json:"request"`
+	Prompt   string    `json:"prompt"`
+	Response string    `json:"response,omitempty"`
+	Cost     float64   `json:"cost,omitempty"`
+	Files    []string  `json:"files,omitempty"`
+	Deletes  []string  `json:"deletes,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// transcriptMu serializes appends so parallel brain calls cannot
+// interleave records within one JSONL line.
+var transcriptMu sync.Mutex
+
+// logTranscript appends one brain call record to the day's transcript
+// file under .agentic/transcripts/, for auditing and for diagnosing
+// extraction failures against the raw response. Disabled by --no-log;
+// logging problems are warnings, never task failures.
+func logTranscript(nodeID, request, prompt string, resp *brain.Response, callErr error) {
+	if flagNoLog {
+		return
+	}
+	entry := transcriptEntry{
+		Time:    time.Now(),
+		Node:    nodeID,
+		Brain:   selectedBrain(),
+		Request: request,
+		Prompt:  prompt,
+	}
+	if resp != nil {
+		entry.Response = resp.Raw
+		entry.Cost = resp.Cost
+		for path := range resp.Files {
+			entry.Files = append(entry.Files, path)
+		}
+		sort.Strings(entry.Files)
+		entry.Deletes = resp.Deletes
+	}
+	if callErr != nil {
+		entry.Error = callErr.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not encode transcript entry: %v\n", err)
+		return
+	}
+
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+	dir := filepath.Join(workspace.DataDir(), "transcripts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not create transcript dir: %v\n", err)
+		return
+	}
+	path := filepath.Join(dir, entry.Time.Format("2006-01-02")+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not open transcript %s: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not write transcript: %v\n", err)
+	}
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [date[:line]]",
+	Short: "Re-extract and stage files from a saved transcript entry",
+	Long: `Replay re-runs extraction over the raw response stored in a transcript
+entry and stages the result, without calling the model again. This
+re-processes a captured response after an extraction fix, and recovers
+work when a crash hit after the brain returned but before staging.
+
+With no argument the newest entry is replayed; "2006-01-02" picks the
+last entry of that day, "2006-01-02:3" its third line.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		}
+		return runReplay(id)
+	},
+}
+
+// runReplay finds the transcript entry named by id and stages its
+// re-extracted files for the recorded node.
+func runReplay(id string) error {
+	entry, where, err := findTranscriptEntry(id)
+	if err != nil {
+		return err
+	}
+	if entry.Response == "" {
+		return fmt.Errorf("transcript entry %s has no response recorded (the call failed before output)", where)
+	}
+
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	if _, ok := g.Nodes[entry.Node]; !ok {
+		return fmt.Errorf("transcript entry %s targets node %q, which is no longer in the graph", where, entry.Node)
+	}
+
+	fmt.Printf("Replaying %s: node %s, %q\n", where, entry.Node, entry.Request)
+	resp, err := brain.ExtractFiles(entry.Response)
+	if err != nil {
+		return fmt.Errorf("re-extracting %s: %w", where, err)
+	}
+	if len(resp.Files) == 0 && len(resp.Deletes) == 0 {
+		fmt.Println("No file changes extracted.")
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+		return nil
+	}
+
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	node := g.Nodes[entry.Node]
+	staged := make(map[string]string, len(resp.Files)+len(resp.Deletes))
+	for path, content := range resp.Files {
+		staged[filepath.Join(node.Path, path)] = content
+	}
+	for _, path := range resp.Deletes {
+		staged[filepath.Join(node.Path, path)] = workspace.DeletedFile
+	}
+	ws.StageFiles(entry.Node, staged)
+	ws.SetStagedInfo(entry.Node, workspace.StagedInfo{
+		Request: entry.Request,
+		Message: resp.Message,
+		Cost:    entry.Cost,
+	})
+	if err := ws.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("[%s] Changes staged: %d file(s), %d deletion(s). Review with 'agentic diff'.\n",
+		entry.Node, len(resp.Files), len(resp.Deletes))
+	return nil
+}
+
+// findTranscriptEntry resolves a replay id to a transcript record. An
+// empty id means the newest entry; "<date>" the last entry of that
+// day's file; "<date>:<N>" its Nth line, 1-based. Returns the entry
+// and a file:line label for messages.
+func findTranscriptEntry(id string) (*transcriptEntry, string, error) {
+	dir := filepath.Join(workspace.DataDir(), "transcripts")
+
+	date, lineStr, _ := strings.Cut(id, ":")
+	if date == "" || date == "last" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+		if err != nil || len(files) == 0 {
+			return nil, "", fmt.Errorf("no transcripts found under %s", dir)
+		}
+		sort.Strings(files)
+		date = strings.TrimSuffix(filepath.Base(files[len(files)-1]), ".jsonl")
+	}
+
+	path := filepath.Join(dir, date+".jsonl")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading transcript %s: %w", path, err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		return nil, "", fmt.Errorf("transcript %s is empty", path)
+	}
+
+	lineNo := len(lines)
+	if lineStr != "" {
+		n, err := strconv.Atoi(lineStr)
+		if err != nil || n < 1 || n > len(lines) {
+			return nil, "", fmt.Errorf("transcript %s has %d entries; %q is not one of them", path, len(lines), lineStr)
+		}
+		lineNo = n
+	}
+
+	entry := &transcriptEntry{}
+	if err := json.Unmarshal([]byte(lines[lineNo-1]), entry); err != nil {
+		return nil, "", fmt.Errorf("parsing %s:%d: %w", path, lineNo, err)
+	}
+	return entry, fmt.Sprintf("%s:%d", date, lineNo), nil
+}
+
+// runTasksParallel processes nodes level by level: all nodes within a
+// dependency level run concurrently, capped at --max-parallel brain
+// calls at a time so a wide level cannot exhaust API rate limits.
+//
+// The first error aborts the batch unless --keep-going is set, in
+// which case independent nodes keep running, nodes whose dependencies
+// failed are skipped, and a final report lists the outcome per node.
+// Staged successes are preserved either way.
+func runTasksParallel(g *graph.Graph, ws *workspace.Workspace, nodes []*graph.Node, request string) error {
+	levels := groupByDependencyLevel(g, nodes)
+	maxParallel := flagMaxParallel
+	if maxParallel < 1 {
+		maxParallel = runtime.GOMAXPROCS(0)
+	}
+	sem := make(chan struct{}, maxParallel)
+
+	type result struct {
+		id  string
+		err error
+	}
+	var succeeded, skipped []string
+	failed := make(map[string]error)
+	var order []string // failed node ids, in completion order
+
+	// failedDep reports whether any dependency of id (transitively)
+	// already failed or was skipped because of a failure.
+	broken := make(map[string]bool)
+	var failedDep func(id string) bool
+	failedDep = func(id string) bool {
+		if broken[id] {
+			return true
+		}
+		for _, dep := range g.Nodes[id].Dependencies {
+			if failedDep(dep) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, level := range levels {
+		if flagVerbose {
+			ids := make([]string, len(level))
+			for j, n := range level {
+				ids[j] = n.ID
+			}
+			fmt.Printf("level %d: %s\n", i, strings.Join(ids, ", "))
+		}
+		var wg sync.WaitGroup
+		results := make(chan result, len(level))
+		for _, node := range level {
+			if flagKeepGoing && failedDep(node.ID) {
+				skipped = append(skipped, node.ID)
+				broken[node.ID] = true
+				continue
+			}
+			wg.Add(1)
+			go func(n *graph.Node) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results <- result{n.ID, runSingleNodeTask(g, ws, n, request)}
+			}(node)
+		}
+		wg.Wait()
+		close(results)
+		for r := range results {
+			if r.err == nil {
+				succeeded = append(succeeded, r.id)
+				continue
+			}
+			if !flagKeepGoing {
+				return r.err
+			}
+			failed[r.id] = r.err
+			order = append(order, r.id)
+			broken[r.id] = true
+		}
+	}
+
+	if !flagKeepGoing || (len(failed) == 0 && len(skipped) == 0) {
+		return nil
+	}
+	sort.Strings(succeeded)
+	fmt.Printf("\n%d succeeded, %d failed, %d skipped\n", len(succeeded), len(failed), len(skipped))
+	for _, id := range succeeded {
+		fmt.Printf("  ok      %s\n", id)
+	}
+	for _, id := range order {
+		fmt.Printf("  FAIL    %s: %v\n", id, failed[id])
+	}
+	for _, id := range skipped {
+		fmt.Printf("  skipped %s (dependency failed)\n", id)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d node(s) failed; staged changes from successful nodes are preserved", len(failed))
+	}
+	return nil
+}
+
+// groupByDependencyLevel buckets the selected nodes by dependency
+// depth: level 0 has no selected dependencies, level N depends only on
+// earlier levels.
+func groupByDependencyLevel(g *graph.Graph, nodes []*graph.Node) [][]*graph.Node {
+	depth := make(map[string]int)
+	var depthOf func(id string) int
+	depthOf = func(id string) int {
+		if d, ok := depth[id]; ok {
+			return d
+		}
+		depth[id] = 0 // breaks accidental cycles; validate() rejects real ones
+		max := 0
+		for _, dep := range g.Nodes[id].Dependencies {
+			if d := depthOf(dep) + 1; d > max {
+				max = d
+			}
+		}
+		depth[id] = max
+		return max
+	}
+
+	byLevel := make(map[int][]*graph.Node)
+	maxLevel := 0
+	for _, node := range nodes {
+		d := depthOf(node.ID)
+		byLevel[d] = append(byLevel[d], node)
+		if d > maxLevel {
+			maxLevel = d
+		}
+	}
+
+	var levels [][]*graph.Node
+	for i := 0; i <= maxLevel; i++ {
+		if len(byLevel[i]) > 0 {
+			sort.Slice(byLevel[i], func(a, b int) bool { return byLevel[i][a].ID < byLevel[i][b].ID })
+			levels = append(levels, byLevel[i])
+		}
+	}
+	return levels
+}
+
+// verboseBundle prints the bundle's file list with token estimates.
+func verboseBundle(b *bundle.Bundle) {
+	paths := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Printf("Bundle %s: %d files, ~%d tokens\n", b.NodeID, len(b.Files), b.EstimateTokens())
+	for _, path := range paths {
+		fmt.Printf("  %s (~%d tokens)\n", path, token.EstimateString(b.Files[path]))
+	}
+	for name := range b.Contracts {
+		fmt.Printf("  [contract] %s\n", name)
+	}
+	for _, skipped := range b.Skipped {
+		fmt.Printf("  [skipped] %s: %s\n", skipped.Path, skipped.Reason)
+	}
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan <request>",
+	Short: "Preview the node processing order for a task",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPlan(strings.Join(args, " "))
+	},
+}
+
+// runPlan prints the order nodes would be processed in.
+func runPlan(request string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	if flagJSON {
+		return printPlanJSON(g, request)
+	}
+	fmt.Printf("Plan for: %s\n\n", request)
+
+	// With --parallel --show-levels, expose the scheduling decision a
+	// parallel run would make instead of the flat order.
+	if flagParallel && flagShowLevels {
+		nodes, err := selectNodes(g, "")
+		if err != nil {
+			return err
+		}
+		for i, level := range groupByDependencyLevel(g, nodes) {
+			ids := make([]string, len(level))
+			for j, n := range level {
+				ids[j] = n.ID
+			}
+			fmt.Printf("Level %d: %s\n", i, strings.Join(ids, ", "))
+		}
+		return nil
+	}
+
+	// Scope the plan to the nodes the request actually touches plus
+	// their transitive dependents, whose contracts might change.
+	if flagNode != "" {
+		if _, ok := g.Nodes[flagNode]; !ok {
+			return fmt.Errorf("node %q not found in graph", flagNode)
+		}
+	}
+	seeds := planSeeds(g, request)
+	if flagDirty {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		seeds = make(map[string]bool)
+		if flagNode != "" {
+			seeds[flagNode] = true
+		}
+		for id := range ws.DirtyNodes {
+			if _, ok := g.Nodes[id]; ok {
+				seeds[id] = true
+			}
+		}
+		if len(seeds) == 0 {
+			fmt.Println("No dirty nodes.")
+			return nil
+		}
+	}
+	if len(seeds) > 0 {
+		impacted := make(map[string]string) // node ID -> role
+		for id := range seeds {
+			impacted[id] = "target"
+		}
+		for id := range seeds {
+			for _, dep := range g.GetReverseDeps(id) {
+				if _, ok := impacted[dep.ID]; !ok {
+					impacted[dep.ID] = "dependent (contract may change)"
+				}
+			}
+		}
+		i := 0
+		for _, id := range g.Order {
+			role, ok := impacted[id]
+			if !ok {
+				continue
+			}
+			i++
+			fmt.Printf("%2d. %-14s %s (toks=%d)\n", i, id, role, g.Nodes[id].TokenCap)
+		}
+		fmt.Printf("\n%d of %d node(s) impacted.\n", len(impacted), len(g.Nodes))
+		return nil
+	}
+
+	fmt.Println("No nodes matched the request; full processing order:")
+	i := 0
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		if flagTag != "" && !node.HasTag(flagTag) {
+			continue
+		}
+		i++
+		fmt.Printf("%2d. %s (toks=%d)\n", i, node.ID, node.TokenCap)
+	}
+	return nil
+}
+
+// planSeeds guesses which nodes a request targets: the --node flag
+// when given, otherwise keyword matches of the request text against
+// node ids, paths, and purposes.
+func planSeeds(g *graph.Graph, request string) map[string]bool {
+	seeds := make(map[string]bool)
+	if flagNode != "" {
+		if _, ok := g.Nodes[flagNode]; ok {
+			seeds[flagNode] = true
+		}
+		return seeds
+	}
+	words := strings.Fields(strings.ToLower(request))
+	for id, node := range g.Nodes {
+		hay := strings.ToLower(id + " " + node.Path)
+		if node.Meta != nil {
+			hay += " " + strings.ToLower(node.Meta.Purpose)
+		}
+		for _, word := range words {
+			if len(word) >= 3 && strings.Contains(hay, word) {
+				seeds[id] = true
+				break
+			}
+		}
+	}
+	return seeds
+}
+
+// printPlanJSON emits the processing order (and, with --parallel, the
+// dependency levels) as JSON for scripting.
+func printPlanJSON(g *graph.Graph, request string) error {
+	type planNode struct {
+		ID     string `json:"id"`
+		Tokens int    `json:"tokens"`
+	}
+	out := struct {
+		Request string     `json:"request"`
+		Order   []planNode `json:"order"`
+		Levels  [][]string `json:"levels,omitempty"`
+	}{Request: request}
+
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		if flagTag != "" && !node.HasTag(flagTag) {
+			continue
+		}
+		out.Order = append(out.Order, planNode{ID: node.ID, Tokens: node.TokenCap})
+	}
+	if flagParallel {
+		nodes, err := selectNodes(g, "")
+		if err != nil {
+			return err
+		}
+		for _, level := range groupByDependencyLevel(g, nodes) {
+			ids := make([]string, len(level))
+			for j, n := range level {
+				ids[j] = n.ID
+			}
+			out.Levels = append(out.Levels, ids)
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show workspace status",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStatus()
+	},
+}
+
+// runStatus prints the workspace status, refreshing dirty nodes from
+// the git working tree first when possible.
+func runStatus() error {
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	if workspace.IsGitRepo() {
+		if g, err := graph.Load(graph.ManifestFileName); err == nil {
+			if err := ws.RefreshDirtyFromGit(g); err != nil && !flagJSON {
+				fmt.Printf("Warning: could not refresh dirty nodes from git: %v\n", err)
+			}
+		}
+	}
+	if flagJSON {
+		return printStatusJSON(ws)
+	}
+	ws.PrintStatus()
+	return nil
+}
+
+// printStatusJSON serializes the workspace state for scripting: staged
+// files per node, dirty nodes, checkpoints, and undo/redo availability.
+func printStatusJSON(ws *workspace.Workspace) error {
+	staged := make(map[string][]string)
+	for _, nodeID := range ws.StagedNodes() {
+		paths := make([]string, 0, len(ws.StagedChanges[nodeID]))
+		for path := range ws.StagedChanges[nodeID] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		staged[nodeID] = paths
+	}
+	out := struct {
+		Staged      map[string][]string    `json:"staged"`
+		DirtyNodes  map[string]string      `json:"dirty_nodes"`
+		Checkpoints []workspace.Checkpoint `json:"checkpoints,omitempty"`
+		UndoSteps   int                    `json:"undo_steps"`
+		RedoSteps   int                    `json:"redo_steps"`
+		CurrentNode string                 `json:"current_node,omitempty"`
+	}{
+		Staged:      staged,
+		DirtyNodes:  ws.DirtyNodes,
+		Checkpoints: ws.Checkpoints,
+		UndoSteps:   len(ws.UndoStack),
+		RedoSteps:   len(ws.RedoStack),
+		CurrentNode: ws.CurrentNode,
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show staged changes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			return printDiffJSON(ws, flagNode)
+		}
+		ws.PrintDiff(flagNode)
+		return nil
+	},
+}
+
+// printDiffJSON lists each staged file with the action an apply would
+// take, as JSON for scripting.
+func printDiffJSON(ws *workspace.Workspace, nodeID string) error {
+	type diffEntry struct {
+		Node   string `json:"node"`
+		Path   string `json:"path"`
+		Action string `json:"action"` // create, modify, or delete
+	}
+	entries := []diffEntry{}
+	for _, id := range ws.StagedNodes() {
+		if nodeID != "" && id != nodeID {
+			continue
+		}
+		paths := make([]string, 0, len(ws.StagedChanges[id]))
+		for path := range ws.StagedChanges[id] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			action := "modify"
+			if ws.StagedChanges[id][path] == workspace.DeletedFile {
+				action = "delete"
+			} else if _, err := os.Stat(path); os.IsNotExist(err) {
+				action = "create"
+			}
+			entries = append(entries, diffEntry{Node: id, Path: path, Action: action})
+		}
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+var unstageCmd = &cobra.Command{
+	Use:   "unstage <node> <path>",
+	Short: "Drop one staged file from a node",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if err := ws.UnstageFile(args[0], args[1]); err != nil {
+			return err
+		}
+		if err := ws.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Unstaged %s from node %s.\n", args[1], args[0])
+		return nil
+	},
+}
+
+var resetCmd = &cobra.Command{
+	Use:     "reset [node]",
+	Aliases: []string{"discard"},
+	Short:   "Drop staged changes without applying them",
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		node := ""
+		if len(args) > 0 {
+			node = args[0]
+		}
+		return runReset(node)
+	},
+}
+
+// runReset clears staged changes for one node or for all of them,
+// leaving the working tree untouched. With --dirty the dirty markers
+// are dropped as well.
+func runReset(nodeID string) error {
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+
+	targets := ws.StagedNodes()
+	if nodeID != "" {
+		if _, ok := ws.StagedChanges[nodeID]; !ok {
+			return fmt.Errorf("node %q has no staged changes", nodeID)
+		}
+		targets = []string{nodeID}
+	}
+	if len(targets) == 0 && !flagResetDirty {
+		fmt.Println("Nothing staged.")
+		return nil
+	}
+
+	if len(targets) > 0 {
+		files := 0
+		for _, id := range targets {
+			files += len(ws.StagedChanges[id])
+		}
+		if !flagYes && !confirm(fmt.Sprintf("Discard %d staged file(s) across %d node(s)?", files, len(targets))) {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		for _, id := range targets {
+			ws.ClearStaged(id)
+		}
+		fmt.Printf("Discarded staged changes for %d node(s).\n", len(targets))
+	}
+
+	if flagResetDirty {
+		cleared := len(ws.DirtyNodes)
+		if nodeID != "" {
+			if _, ok := ws.DirtyNodes[nodeID]; ok {
+				cleared = 1
+			} else {
+				cleared = 0
+			}
+			delete(ws.DirtyNodes, nodeID)
+		} else {
+			ws.DirtyNodes = make(map[string]string)
+		}
+		if cleared > 0 {
+			fmt.Printf("Cleared %d dirty marker(s).\n", cleared)
+		}
+	}
+
+	return ws.Save()
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [node...]",
+	Short: "Apply staged changes to disk, optionally only for the named nodes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runApply(args)
+	},
+}
+
+// runApply evaluates policies, checkpoints, writes staged changes, and
+// runs node checks. With node arguments only those nodes are applied;
+// the rest stay staged.
+func runApply(args []string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+
+	if flagRecheck {
+		return runRecheck(g, ws)
+	}
+
+	staged := ws.StagedNodes()
+	if len(staged) == 0 {
+		return fmt.Errorf("no staged changes")
+	}
+	if len(args) > 0 {
+		isStaged := make(map[string]bool, len(staged))
+		for _, id := range staged {
+			isStaged[id] = true
+		}
+		staged = staged[:0]
+		for _, id := range args {
+			if !isStaged[id] {
+				return fmt.Errorf("node %q has no staged changes", id)
+			}
+			staged = append(staged, id)
+		}
+		sort.Strings(staged)
+	}
+
+	for _, nodeID := range staged {
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("staged node %q no longer in graph", nodeID)
+		}
+		if flagFixHeaders {
+			if fixed := policy.FixHeaders(node, ws.StagedChanges[nodeID]); len(fixed) > 0 {
+				fmt.Printf("[%s] added license header to %d file(s)\n", nodeID, len(fixed))
+			}
+		}
+		b, err := bundle.Build(g, node)
+		if err != nil {
+			return err
+		}
+		violations := policy.Evaluate(node, b, ws.StagedChanges[nodeID])
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		if policy.HasErrors(violations) {
+			return exitErrf(ExitPolicy, "policy violations in node %s, not applying", nodeID)
+		}
+	}
+
+	// Nodes flagged review_required refuse to apply, even with --yes,
+	// until the reviewer acknowledges with --review-confirmed.
+	if !flagReviewConfirmed {
+		var needReview []string
+		for _, nodeID := range staged {
+			if node := g.Nodes[nodeID]; node != nil && node.Meta != nil && node.Meta.ReviewRequired {
+				needReview = append(needReview, nodeID)
+			}
+		}
+		if len(needReview) > 0 {
+			return fmt.Errorf("node(s) %s require human review; re-run with --review-confirmed after reviewing the diff",
+				strings.Join(needReview, ", "))
+		}
+	}
+
+	// A mirror apply never touches the originals, so it needs no
+	// confirmation, checkpoint, or post-apply checks.
+	if flagOutputDir != "" {
+		written, err := ws.ApplyChanges(workspace.ApplyOptions{
+			Force:     flagForce,
+			Nodes:     args,
+			OutputDir: flagOutputDir,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d file(s) under %s; originals untouched, staging preserved.\n",
+			len(written), flagOutputDir)
+		return nil
+	}
+
+	if !flagYes && !confirm(fmt.Sprintf("Apply staged changes to %d node(s)?", len(staged))) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	if workspace.IsGitRepo() {
+		if err := ws.CreateCheckpoint("pre-apply"); err != nil {
+			fmt.Printf("Warning: could not create checkpoint: %v\n", err)
+		}
+	}
+
+	// Capture per-node changed files before apply clears staging, so
+	// templated checks can reference them.
+	changedByNode := make(map[string][]string, len(staged))
+	stagedInfos := make(map[string]workspace.StagedInfo, len(staged))
+	for _, nodeID := range staged {
+		for path := range ws.StagedChanges[nodeID] {
+			changedByNode[nodeID] = append(changedByNode[nodeID], path)
+		}
+		sort.Strings(changedByNode[nodeID])
+		stagedInfos[nodeID] = ws.StagedInfoFor(nodeID)
+	}
+
+	if flagConfirmEach {
+		return applyConfirmEach(g, ws, staged, changedByNode)
+	}
+
+	written, err := ws.ApplyChanges(workspace.ApplyOptions{
+		Atomic:   !flagNoAtomic,
+		Force:    flagForce,
+		Nodes:    args,
+		Validate: checkValidator(g, flagSkipChecks),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d file(s).\n", len(written))
+
+	if out, err := exec.Command("go", "build", "./...").CombinedOutput(); err != nil {
+		fmt.Printf("Warning: build failed after apply (fix and run 'agentic apply --recheck'):\n%s", out)
+	}
+
+	if flagCommit {
+		msg := buildCommitMessage(staged, stagedInfos, written)
+		if err := workspace.GitCommit(msg, written); err != nil {
+			return fmt.Errorf("committing applied changes: %w", err)
+		}
+		fmt.Printf("Committed %d file(s).\n", len(written))
+	}
+	return nil
+}
+
+// checkValidator adapts policy.RunChecks into the apply pipeline's
+// per-node validation hook, so failing checks roll an atomic apply
+// back. With skip set no validator is installed.
+func checkValidator(g *graph.Graph, skip bool) func(string, []string) error {
+	if skip {
+		return nil
+	}
+	return func(nodeID string, written []string) error {
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			return nil
+		}
+		if err := policy.RunChecks(node, written, flagVerbose); err != nil {
+			return exitErrf(ExitChecks, "node %s checks failed: %w", nodeID, err)
+		}
+		return nil
+	}
+}
+
+// buildCommitMessage assembles a structured commit message for
+// apply --commit: the originating request, each node's brain message
+// and cost, and the written file list, so the git history documents
+// what the AI did and why.
+func buildCommitMessage(nodes []string, infos map[string]workspace.StagedInfo, written []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "agentic: apply %s\n", strings.Join(nodes, ", "))
+
+	for _, nodeID := range nodes {
+		info := infos[nodeID]
+		if info.Request != "" {
+			fmt.Fprintf(&sb, "\nRequest: %s\n", info.Request)
+			break
+		}
+	}
+	for _, nodeID := range nodes {
+		info := infos[nodeID]
+		if info.Message == "" && info.Cost == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\nNode %s", nodeID)
+		if info.Cost > 0 {
+			fmt.Fprintf(&sb, " (cost $%.4f)", info.Cost)
+		}
+		sb.WriteString(":\n")
+		if info.Message != "" {
+			for _, line := range strings.Split(strings.TrimSpace(info.Message), "\n") {
+				fmt.Fprintf(&sb, "  %s\n", line)
+			}
+		}
+	}
+
+	sb.WriteString("\nFiles:\n")
+	for _, path := range written {
+		fmt.Fprintf(&sb, "  %s\n", path)
+	}
+	return sb.String()
+}
+
+// runRecheck re-runs the build and node checks for the most recent
+// apply without touching any files, for the "apply, build broke, I
+// fixed it, now verify" flow.
+func runRecheck(g *graph.Graph, ws *workspace.Workspace) error {
+	last := ws.LastApply()
+	if last == nil {
+		return fmt.Errorf("no previous apply to recheck")
+	}
+
+	changed := make([]string, 0, len(last.Backups))
+	for path := range last.Backups {
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+
+	if out, err := exec.Command("go", "build", "./...").CombinedOutput(); err != nil {
+		return fmt.Errorf("build still failing:\n%s", out)
+	}
+	fmt.Println("Build OK.")
+
+	seen := make(map[string]bool)
+	for _, nodeID := range last.Nodes {
+		if seen[nodeID] {
+			continue
+		}
+		seen[nodeID] = true
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			continue
+		}
+		if err := policy.RunChecks(node, changed, flagVerbose); err != nil {
+			return exitErrf(ExitChecks, "node %s checks failed: %w", nodeID, err)
+		}
+	}
+	fmt.Printf("Rechecked %d node(s); all checks passed.\n", len(seen))
+	return nil
+}
+
+// applyConfirmEach applies staged nodes one at a time, running each
+// node's checks and pausing for confirmation before the next node, so a
+// problem stops the apply before the rest are written.
+func applyConfirmEach(g *graph.Graph, ws *workspace.Workspace, staged []string, changedByNode map[string][]string) error {
+	total := 0
+	for i, nodeID := range staged {
+		written, err := ws.ApplyNode(nodeID, i > 0)
+		if err != nil {
+			return err
+		}
+		total += len(written)
+		fmt.Printf("[%s] applied %d file(s).\n", nodeID, len(written))
+
+		if out, err := exec.Command("go", "build", "./...").CombinedOutput(); err != nil {
+			fmt.Printf("Warning: build failed after applying %s:\n%s", nodeID, out)
+		}
+		if !flagSkipChecks {
+			if node, ok := g.Nodes[nodeID]; ok {
+				if err := policy.RunChecks(node, changedByNode[nodeID], flagVerbose); err != nil {
+					return exitErrf(ExitChecks, "node %s checks failed: %w", nodeID, err)
+				}
+			}
+		}
+
+		if i < len(staged)-1 && !confirm(fmt.Sprintf("Continue to node %s?", staged[i+1])) {
+			fmt.Printf("Stopped after %s; remaining nodes left staged.\n", nodeID)
+			return nil
+		}
+	}
+	fmt.Printf("Applied %d file(s) across %d node(s).\n", total, len(staged))
+	return nil
+}
+
+// confirm prompts the user with a yes/no question.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	var answer string
+	fmt.Scanln(&answer)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Revert the last applied changes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if err := ws.Undo(flagDryRun); err != nil {
+			return err
+		}
+		if !flagDryRun {
+			fmt.Println("Reverted last apply.")
+		}
+		return nil
+	},
+}
+
+var redoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Reapply the changes reverted by the last undo",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if err := ws.Redo(); err != nil {
+			return err
+		}
+		fmt.Println("Reapplied last undone changes.")
+		return nil
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <checkpoint>",
+	Short: "Hard-reset the git tree to a checkpoint",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if !flagDryRun && !flagYes && !confirm(fmt.Sprintf("Hard-reset to checkpoint %q? Uncommitted work will be lost.", args[0])) {
+			fmt.Println("Aborted.")
+			return nil
+		}
+		if err := ws.Rollback(args[0], flagDryRun); err != nil {
+			return err
+		}
+		if !flagDryRun {
+			fmt.Printf("Rolled back to checkpoint %q.\n", args[0])
+		}
+		return nil
+	},
+}
+
+var mergeCmd = &cobra.Command{
+	Use:     "merge <target> <source...>",
+	Aliases: []string{"unsplit"},
+	Short:   "Merge leaf nodes back into one node",
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMerge(args[0], args[1:])
+	},
+}
+
+// runMerge combines source nodes into the target in the manifest:
+// dependencies are unioned, token caps summed, and dependents rewired.
+// The inverse of split at the graph level; moving the source files is
+// left to the user.
+func runMerge(targetID string, sourceIDs []string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	srcPaths := make([]string, 0, len(sourceIDs))
+	for _, id := range sourceIDs {
+		if node, ok := g.Nodes[id]; ok {
+			srcPaths = append(srcPaths, node.Path)
+		}
+	}
+	if err := g.MergeNodes(targetID, sourceIDs); err != nil {
+		return err
+	}
+	if err := g.Save(); err != nil {
+		return err
+	}
+	target := g.Nodes[targetID]
+	fmt.Printf("Merged %s into %s (toks=%d, deps=[%s]).\n",
+		strings.Join(sourceIDs, ", "), targetID, target.TokenCap, strings.Join(target.Dependencies, ","))
+	fmt.Printf("Move the source files into %s and remove:\n", target.Path)
+	for _, path := range srcPaths {
+		fmt.Printf("  %s\n", path)
+	}
+	return nil
+}
+
+var splitCmd = &cobra.Command{
+	Use:   "split <node>",
+	Short: "Analyze an over-budget node and suggest splits",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSplit(args[0])
+	},
+}
+
+// runSplit reports a node's size against its budget and suggests how
+// to split it.
+func runSplit(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+	b, err := bundle.Build(g, node)
+	if err != nil {
+		return err
+	}
+
+	estimate := b.EstimateTokens()
+	fmt.Printf("Node %s: ~%d tokens (cap %d)\n\n", node.ID, estimate, node.TokenCap)
+
+	type fileSize struct {
+		path   string
+		tokens int
+	}
+	listSorted := func(files map[string]string) {
+		var sizes []fileSize
+		for path, content := range files {
+			sizes = append(sizes, fileSize{path, token.EstimateFile(path, content)})
+		}
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i].tokens > sizes[j].tokens })
+		for _, f := range sizes {
+			fmt.Printf("  %6d  %s\n", f.tokens, f.path)
+		}
+	}
+
+	// The subtotals reconcile with EstimateTokens so the listing adds
+	// up to the overflow number.
+	filesSub := token.EstimateMap(b.Files)
+	contractsSub := token.EstimateMap(b.Contracts)
+
+	fmt.Printf("Files (~%d tokens):\n", filesSub)
+	listSorted(b.Files)
+	if len(b.Contracts) > 0 {
+		fmt.Printf("\nDependency contracts (~%d tokens):\n", contractsSub)
+		listSorted(b.Contracts)
+	}
+	fmt.Printf("\nBreakdown: files %d + contracts %d + overhead %d = %d total\n",
+		filesSub, contractsSub, estimate-filesSub-contractsSub, estimate)
+
+	if flagSuggest {
+		fmt.Println("\nProposed split:")
+		for i, group := range policy.ProposeSplit(node, b) {
+			fmt.Printf("\n  %s-%d (%s, ~%d tokens):\n", node.ID, i+1, group.Dir, group.Tokens)
+			for _, path := range group.Files {
+				fmt.Printf("    %s\n", path)
+			}
+		}
+		return nil
+	}
+
+	if flagSplitApply {
+		return applySplit(g, node, policy.ProposeSplit(node, b))
+	}
+
+	if policy.ShouldSplit(node, estimate) {
+		fmt.Println("\nNode exceeds its budget. Suggestions:")
+		for _, s := range policy.SuggestSplit(node, b) {
+			fmt.Printf("  - %s\n", s)
+		}
+	}
+	return nil
+}
+
+// applySplit turns the proposed directory grouping into real child
+// nodes: files move into new node directories, each child gets a
+// NODE.meta.yaml stub, the parent becomes a composite over its
+// children, and the manifest is rewritten. Every file written, moved,
+// or deleted is recorded on the undo stack so the whole split can be
+// undone.
+func applySplit(g *graph.Graph, node *graph.Node, groups []policy.SplitGroup) error {
+	if len(groups) < 2 {
+		return fmt.Errorf("node %s has only one directory group; nothing to split", node.ID)
+	}
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nThis will move files on disk, create child node directories, and rewrite %s.\n", graph.ManifestFileName)
+
+	applied := workspace.LastApplied{
+		Nodes:     []string{node.ID},
+		Backups:   make(map[string]workspace.FileBackup),
+		After:     make(map[string]string),
+		AppliedAt: time.Now(),
+	}
+	record := func(path, content string, existed bool, prev string) {
+		if _, done := applied.Backups[path]; !done {
+			applied.Backups[path] = workspace.FileBackup{Existed: existed, Content: prev}
+		}
+		applied.After[path] = content
+	}
+
+	manifestPrev, err := os.ReadFile(g.Path)
+	if err != nil {
+		return fmt.Errorf("reading manifest: %w", err)
+	}
+
+	created := 0
+	origDeps := append([]string(nil), node.Dependencies...)
+	for i, group := range groups {
+		childID := fmt.Sprintf("%s-%d", node.ID, i+1)
+		if !confirm(fmt.Sprintf("Create child %s from %s (%d file(s), ~%d tokens)?",
+			childID, group.Dir, len(group.Files), group.Tokens)) {
+			fmt.Printf("Skipped %s.\n", childID)
+			continue
+		}
+		childPath := filepath.Join(filepath.Dir(node.Path), childID)
+
+		for _, rel := range group.Files {
+			// Bundle keys are node-relative; resolve them against the
+			// node directory before touching disk.
+			path := filepath.Join(node.Path, rel)
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			newPath := filepath.Join(childPath, "SRC", filepath.Base(rel))
+			if err := workspace.WriteFile(newPath, string(content)); err != nil {
+				return err
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			record(newPath, string(content), false, "")
+			record(path, workspace.DeletedFile, true, string(content))
+		}
+
+		meta := fmt.Sprintf("id: %s\ntype: leaf\npurpose: \"Split from %s (%s)\"\nbudgets:\n  token_cap: %d\n",
+			childID, node.ID, group.Dir, group.Tokens+group.Tokens/4)
+		metaPath := filepath.Join(childPath, graph.MetaFileName)
+		if err := workspace.WriteFile(metaPath, meta); err != nil {
+			return err
+		}
+		record(metaPath, meta, false, "")
+
+		g.Nodes[childID] = &graph.Node{
+			ID:           childID,
+			Type:         graph.NodeTypeLeaf,
+			Path:         childPath,
+			Dependencies: append([]string(nil), origDeps...),
+			TokenCap:     group.Tokens + group.Tokens/4,
+			Version:      1,
+		}
+		node.Dependencies = append(node.Dependencies, childID)
+		created++
+	}
+	if created == 0 {
+		fmt.Println("No children created; nothing changed.")
+		return nil
+	}
+
+	node.Type = graph.NodeTypeComposite
+	// Relink dependents from scratch: the children changed the edges,
+	// and TopologicalSort walks the Dependents pointers.
+	for _, n := range g.Nodes {
+		n.Dependents = nil
+	}
+	for _, n := range g.Nodes {
+		for _, dep := range n.Dependencies {
+			if target, ok := g.Nodes[dep]; ok {
+				target.Dependents = append(target.Dependents, n)
+			}
+		}
+	}
+	order, err := g.TopologicalSort()
+	if err != nil {
+		return fmt.Errorf("split broke the graph: %w", err)
+	}
+	g.Order = order
+	if err := g.Save(); err != nil {
+		return err
+	}
+	manifestNew, err := os.ReadFile(g.Path)
+	if err != nil {
+		return err
+	}
+	record(g.Path, string(manifestNew), true, string(manifestPrev))
+
+	if err := ws.RecordApplied(applied); err != nil {
+		return err
+	}
+	fmt.Printf("Split %s into %d child node(s); 'agentic undo' reverts it.\n", node.ID, created)
+	return nil
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [node]",
+	Short: "Watch node directories and re-estimate tokens on change",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID := ""
+		if len(args) == 1 {
+			nodeID = args[0]
+		}
+		return runWatch(nodeID)
+	},
+}
+
+// runWatch polls the watched node directories for modification-time
+// changes (polling keeps the tool dependency-free), invalidates the
+// bundle cache for changed nodes, re-estimates their tokens, and warns
+// the moment a node crosses its cap. Runs until interrupted.
+func runWatch(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	var watched []*graph.Node
+	if nodeID != "" {
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node %q not found", nodeID)
+		}
+		watched = append(watched, node)
+	} else {
+		for _, id := range g.Order {
+			watched = append(watched, g.Nodes[id])
+		}
+	}
+
+	fmt.Printf("Watching %d node(s); Ctrl-C to stop.\n", len(watched))
+
+	state := make(map[string]string) // node ID -> directory fingerprint
+	for {
+		for _, node := range watched {
+			fp := dirFingerprint(node.Path)
+			if prev, seen := state[node.ID]; seen && fp == prev {
+				continue
+			}
+			first := state[node.ID] == ""
+			state[node.ID] = fp
+
+			bundle.InvalidateCache(node.ID)
+			b, err := bundle.Build(g, node)
+			if err != nil {
+				fmt.Printf("[%s] %s: %v\n", time.Now().Format("15:04:05"), node.ID, err)
+				continue
+			}
+			estimate := b.EstimateTokens()
+			status := "ok"
+			if node.TokenCap > 0 && estimate > node.TokenCap {
+				status = "OVER BUDGET"
+			}
+			marker := "changed"
+			if first {
+				marker = "watching"
+			}
+			fmt.Printf("[%s] %s: %s, ~%d/%d tokens (%s)\n",
+				time.Now().Format("15:04:05"), node.ID, marker, estimate, node.TokenCap, status)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// dirFingerprint summarizes a directory tree's file paths, sizes, and
+// modification times; any content change alters the fingerprint.
+func dirFingerprint(dir string) string {
+	var sb strings.Builder
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return sb.String()
+}
+
+var selfTestCmd = &cobra.Command{
+	Use:   "self-test",
+	Short: "Exercise the core pipeline against the bundled testproject",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSelfTest()
+	},
+}
+
+// runSelfTest smoke-tests the installation: discover, graph load,
+// bundling, a mock brain run, and the staged diff, all against
+// testproject/ and without touching any real state. Each stage reports
+// pass or fail.
+func runSelfTest() error {
+	const dir = "testproject"
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("%s/ not found; run self-test from the agentic repository root", dir)
+	}
+
+	failed := 0
+	report := func(stage string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("  FAIL  %s: %v\n", stage, err)
+		} else {
+			fmt.Printf("  ok    %s\n", stage)
+		}
+	}
+
+	discovered, err := graph.Discover(dir)
+	if err == nil && len(discovered.Nodes) == 0 {
+		err = fmt.Errorf("no nodes found")
+	}
+	report("discover", err)
+
+	// Node paths in the manifest are project-relative, so the rest of
+	// the pipeline runs from inside the test project.
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(cwd)
+
+	g, err := graph.Load(graph.ManifestFileName)
+	report("graph", err)
+	if err != nil {
+		return fmt.Errorf("self-test failed at graph load")
+	}
+
+	// In-memory workspace only: nothing under testproject/ is written.
+	ws := &workspace.Workspace{
+		StagedChanges: make(map[string]map[string]string),
+		DirtyNodes:    make(map[string]string),
+	}
+
+	var bundleErr, runErr error
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		b, err := bundle.Build(g, node)
+		if err != nil {
+			bundleErr = fmt.Errorf("node %s: %w", id, err)
+			break
+		}
+		prompt := brain.BuildPrompt(node, b, "self-test: describe this node")
+		resp, err := brain.Call("mock", prompt)
+		if err != nil {
+			runErr = fmt.Errorf("node %s: %w", id, err)
+			break
+		}
+		staged := make(map[string]string, len(resp.Files))
+		for path, content := range resp.Files {
+			staged[filepath.Join(node.Path, path)] = content
+		}
+		ws.StageFiles(id, staged)
+	}
+	report("bundle", bundleErr)
+	report("mock run", runErr)
+
+	fmt.Println("\nStaged diff from the mock run:")
+	ws.PrintDiff("")
+
+	if failed > 0 {
+		return fmt.Errorf("self-test: %d stage(s) failed", failed)
+	}
+	fmt.Println("Self-test passed.")
+	return nil
+}
+
+var contractsCmd = &cobra.Command{
+	Use:   "contracts",
+	Short: "Manage the contract hash baseline",
+}
+
+var contractsUpdateCmd = &cobra.Command{
+	Use:   "update [node]",
+	Short: "Recompute and store contract hashes for all nodes or one",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID := ""
+		if len(args) > 0 {
+			nodeID = args[0]
+		}
+		return runContractsUpdate(nodeID)
+	},
+}
+
+var contractsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Report nodes whose contracts changed since the baseline",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runContractsCheck()
+	},
+}
+
+var contractsExportCmd = &cobra.Command{
+	Use:   "export [node]",
+	Short: "Write exported APIs into each node's CONTRACTS/ directory",
+	Long: `Export renders a node's exported Go symbols into
+<node-dir>/CONTRACTS/api.txt. Bundles of dependent nodes pick up every
+file in a dependency's CONTRACTS/ directory automatically, so after an
+export the model sees the real signatures it must call. The file is
+regenerated wholesale; hand-written contract files alongside it are
+left alone.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID := ""
+		if len(args) > 0 {
+			nodeID = args[0]
+		}
+		return runContractsExport(nodeID)
+	},
+}
+
+// runContractsExport writes the generated api.txt contract for one
+// node or for every node exporting symbols.
+func runContractsExport(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	targets := g.Order
+	if nodeID != "" {
+		if _, ok := g.Nodes[nodeID]; !ok {
+			return fmt.Errorf("node %q not found", nodeID)
+		}
+		targets = []string{nodeID}
+	}
+
+	exported := 0
+	for _, id := range targets {
+		node := g.Nodes[id]
+		symbols, err := policy.ExportedSymbols(node.Path)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", id, err)
+		}
+		if len(symbols) == 0 {
+			if nodeID != "" {
+				fmt.Printf("Node %s exports no symbols; nothing written.\n", id)
+			}
+			continue
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "# Exported API of node %s.\n", id)
+		sb.WriteString("# Generated by 'agentic contracts export'; do not edit by hand.\n\n")
+		for _, file := range policy.SortedSymbolFiles(symbols) {
+			fmt.Fprintf(&sb, "%s:\n", file)
+			for _, sig := range symbols[file] {
+				fmt.Fprintf(&sb, "  %s\n", sig)
+			}
+			sb.WriteString("\n")
+		}
+
+		path := filepath.Join(node.Path, bundle.ContractsDirName, "api.txt")
+		if err := workspace.WriteFile(path, sb.String()); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Printf("  %s -> %s\n", id, path)
+		exported++
+	}
+	fmt.Printf("Exported contracts for %d node(s).\n", exported)
+	return nil
+}
+
+// runContractsUpdate refreshes the stored contract baseline, for every
+// node or just the named one.
+func runContractsUpdate(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	if nodeID != "" {
+		node, ok := g.Nodes[nodeID]
+		if !ok {
+			return fmt.Errorf("node %q not found", nodeID)
+		}
+		if err := policy.UpdateContractHash(".", node); err != nil {
+			return err
+		}
+		fmt.Printf("Updated contract baseline for %s.\n", nodeID)
+		return nil
+	}
+	rehashed, err := policy.UpdateContractHashes(".", g.Nodes, flagForce)
+	if err != nil {
+		return err
+	}
+	if len(rehashed) == 0 {
+		fmt.Println("Contract baseline already up to date.")
+	} else {
+		fmt.Printf("Rehashed %d node(s): %s\n", len(rehashed), strings.Join(rehashed, ", "))
+	}
+	return nil
+}
+
+// runContractsCheck compares each node's current exported API against
+// the stored baseline.
+func runContractsCheck() error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	stored, err := policy.LoadContractHashes()
+	if err != nil {
+		return err
+	}
+	if len(stored) == 0 {
+		return fmt.Errorf("no contract baseline; run 'agentic contracts update' first")
+	}
+
+	changed := 0
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		if _, ok := stored[id]; !ok {
+			fmt.Printf("  %s: no baseline\n", id)
+			continue
+		}
+		drifted, err := policy.HasContractChanged(id, node.Path)
+		if err != nil {
+			return err
+		}
+		if drifted {
+			changed++
+			fmt.Printf("  %s: contracts changed\n", id)
+		}
+	}
+	if changed == 0 {
+		fmt.Println("All contracts match the baseline.")
+	} else {
+		fmt.Printf("%d node(s) changed since the baseline.\n", changed)
+	}
+	return nil
+}
+
+var apiCmd = &cobra.Command{
+	Use:   "api <node>",
+	Short: "List a node's exported Go symbols",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAPI(args[0])
+	},
+}
+
+// runAPI prints the exported API surface of a node, grouped by file,
+// as a quick reference when wiring up new dependencies.
+func runAPI(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+
+	symbols, err := policy.ExportedSymbols(node.Path)
+	if err != nil {
+		return err
+	}
+	if len(symbols) == 0 {
+		fmt.Printf("Node %s exports no symbols.\n", node.ID)
+		return nil
+	}
+	for _, file := range policy.SortedSymbolFiles(symbols) {
+		fmt.Printf("%s:\n", file)
+		for _, sig := range symbols[file] {
+			fmt.Printf("  %s\n", sig)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check external requirements",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		claude := &brain.ClaudeAdapter{}
+		if err := claude.CheckAvailable(); err != nil {
+			return err
+		}
+		fmt.Println("claude CLI: OK")
+		return nil
+	},
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Token estimation utilities",
+}
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Show estimated token usage per node against its cap",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTokens()
+	},
+}
+
+// runTokens prints the budget dashboard: one bundle estimate per node
+// against its TokenCap, over-budget nodes highlighted, and the repo
+// total.
+func runTokens() error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+
+	type nodeTokens struct {
+		ID      string  `json:"id"`
+		Tokens  int     `json:"tokens"`
+		Cap     int     `json:"cap"`
+		Percent float64 `json:"percent"`
+		Over    bool    `json:"over"`
+	}
+	rows := make([]nodeTokens, 0, len(g.Nodes))
+	total := 0
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		b, err := bundle.Build(g, node)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", id, err)
+		}
+		estimate := b.EstimateTokens()
+		total += estimate
+		row := nodeTokens{ID: id, Tokens: estimate, Cap: node.TokenCap}
+		if node.TokenCap > 0 {
+			row.Percent = 100 * float64(estimate) / float64(node.TokenCap)
+			row.Over = estimate > node.TokenCap
+		}
+		rows = append(rows, row)
+	}
+	if flagSort == "tokens" {
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Tokens > rows[j].Tokens })
+	}
+
+	if flagTokensFormat == "json" || flagJSON {
+		out := struct {
+			Nodes []nodeTokens `json:"nodes"`
+			Total int          `json:"total"`
+		}{rows, total}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("%-14s %8s %8s %6s\n", "NODE", "TOKENS", "CAP", "USED")
+	for _, row := range rows {
+		line := fmt.Sprintf("%-14s %8d %8d %5.0f%%", row.ID, row.Tokens, row.Cap, row.Percent)
+		if row.Over {
+			line = "\x1b[31m" + line + "  OVER\x1b[0m"
+		}
+		fmt.Println(line)
+	}
+	budget := token.GetBudget("default")
+	fmt.Printf("\nTotal: ~%d tokens across %d node(s); model budget %d available (%s).\n",
+		total, len(rows), budget.Available(), budget.Model)
+	return nil
+}
+
+var tokenCalibrateCmd = &cobra.Command{
+	Use:   "calibrate <node>",
+	Short: "Measure the chars-per-token ratio for a node's files",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTokenCalibrate(args[0])
+	},
+}
+
+// runTokenCalibrate reports a node's corpus size against the heuristic
+// estimate and, given --actual-tokens from a real model response,
+// derives the true ratio and a suggested divisor.
+func runTokenCalibrate(nodeID string) error {
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+	b, err := bundle.Build(g, node)
+	if err != nil {
+		return err
+	}
+
+	c := token.Calibrate(b.Files, flagActualTokens)
+	fmt.Printf("Node %s: %d files, %d chars\n", node.ID, len(b.Files), c.Chars)
+	fmt.Printf("Heuristic estimate: ~%d tokens\n", c.EstimatedTokens)
+	if c.ActualTokens == 0 {
+		fmt.Println("\nPass --actual-tokens with the token count a model reported for this")
+		fmt.Println("bundle to measure the real chars-per-token ratio.")
+		return nil
+	}
+	fmt.Printf("Actual: %d tokens, %.2f chars/token\n", c.ActualTokens, c.CharsPerToken)
+	fmt.Printf("Suggested divisor: %d\n", c.SuggestedDivisor)
+	return nil
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save or restore the agentic workspace state",
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <name>",
+	Short: "Save the workspace state under a name",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ws, err := workspace.Load()
+		if err != nil {
+			return err
+		}
+		if err := ws.SnapshotSave(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Saved workspace snapshot %q.\n", args[0])
+		return nil
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Short: "Restore the workspace state from a named snapshot",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := workspace.SnapshotRestore(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Restored workspace snapshot %q.\n", args[0])
+		return nil
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved workspace snapshots",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names, err := workspace.SnapshotList()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Println("No snapshots.")
+			return nil
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var replCmd = &cobra.Command{
+	Use:   "repl",
+	Short: "Interactive mode",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return StartREPL()
+	},
+}
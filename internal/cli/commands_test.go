@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aid/agentic/internal/bundle"
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/policy"
+)
+
+// TestApplySplitMovesFiles splits a node in a testproject-shaped tree
+// and asserts the files actually land in the child node directories.
+// Bundle keys are node-relative, so this guards the path resolution in
+// applySplit: with cwd-relative reads the prefix check rejected every
+// file and the split produced empty children.
+func TestApplySplitMovesFiles(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("AGENTIC_HOME", filepath.Join(root, ".agentic"))
+	if err := os.MkdirAll(filepath.Join(root, ".agentic"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two directory groups under the node plus the root meta file, with
+	// strictly decreasing sizes so ProposeSplit orders them SRC, UTIL,
+	// (root).
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srcContent := "package big\n\n// " + strings.Repeat("a", 400) + "\nfunc A() {}\n"
+	utilContent := "package util\n\nfunc B() {}\n"
+	write("GRAPH.manifest", "L:big path=nodes/big deps=[] toks=100 ver=1\n")
+	write("nodes/big/NODE.meta.yaml", "id: big\ntype: leaf\n")
+	write("nodes/big/SRC/a.go", srcContent)
+	write("nodes/big/UTIL/b.go", utilContent)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	// applySplit confirms each child on stdin: yes to the two real
+	// groups, no to the (root) group holding the meta file.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteString("y\ny\nn\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	oldStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		t.Fatalf("graph load: %v", err)
+	}
+	node := g.Nodes["big"]
+	b, err := bundle.Build(g, node)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	if err := applySplit(g, node, policy.ProposeSplit(node, b)); err != nil {
+		t.Fatalf("applySplit: %v", err)
+	}
+
+	for _, c := range []struct{ path, want string }{
+		{"nodes/big-1/SRC/a.go", srcContent},
+		{"nodes/big-2/SRC/b.go", utilContent},
+	} {
+		got, err := os.ReadFile(c.path)
+		if err != nil {
+			t.Fatalf("child file not moved: %v", err)
+		}
+		if string(got) != c.want {
+			t.Errorf("%s: content changed during split", c.path)
+		}
+	}
+	for _, gone := range []string{"nodes/big/SRC/a.go", "nodes/big/UTIL/b.go"} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after split", gone)
+		}
+	}
+
+	manifest, err := os.ReadFile(graph.ManifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"big-1", "big-2", "C:big"} {
+		if !strings.Contains(string(manifest), want) {
+			t.Errorf("manifest missing %q after split:\n%s", want, manifest)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes for CI gating. Generic failures exit 1; the codes below
+// let a pipeline distinguish why agentic refused to proceed.
+const (
+	ExitPolicy = 2 // a policy violation blocked the operation
+	ExitBudget = 3 // a bundle exceeded the available token budget
+	ExitChecks = 4 // a node check command failed
+)
+
+// exitError carries a process exit code alongside the underlying
+// error; Execute unwraps it to select the code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitErrf builds an error that makes the process exit with code.
+func exitErrf(code int, format string, args ...any) error {
+	return &exitError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode resolves the process exit code for a command error.
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return 1
+}
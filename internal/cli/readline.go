@@ -0,0 +1,263 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// replHistoryPath is where REPL history persists between sessions.
+const replHistoryPath = ".agentic/repl_history"
+
+// maxHistoryEntries caps the history file length.
+const maxHistoryEntries = 500
+
+// termios mirrors the kernel's struct termios for the TCGETS/TCSETS
+// ioctls, so the REPL can switch the terminal into raw mode without a
+// terminal dependency.
+type termios struct {
+	Iflag, Oflag, Cflag, Lflag uint32
+	Line                       uint8
+	Cc                         [19]uint8
+	Ispeed, Ospeed             uint32
+}
+
+const (
+	ioctlTCGETS = 0x5401
+	ioctlTCSETS = 0x5402
+)
+
+// getTermios reads the terminal settings of fd; the error doubles as
+// an "is this a terminal" probe.
+func getTermios(fd uintptr) (*termios, error) {
+	var t termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlTCGETS, uintptr(unsafe.Pointer(&t)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+// setTermios applies terminal settings to fd.
+func setTermios(fd uintptr, t *termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlTCSETS, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// lineReader reads REPL input lines with history recall on the arrow
+// keys. When stdin is not a terminal it degrades to plain buffered
+// reads so piped input keeps working.
+type lineReader struct {
+	history     []string
+	interactive bool
+	scanner     *bufio.Scanner
+}
+
+// newLineReader probes the terminal and loads persisted history.
+func newLineReader() *lineReader {
+	r := &lineReader{}
+	if _, err := getTermios(os.Stdin.Fd()); err == nil {
+		r.interactive = true
+	} else {
+		r.scanner = bufio.NewScanner(os.Stdin)
+	}
+	if data, err := os.ReadFile(replHistoryPath); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				r.history = append(r.history, line)
+			}
+		}
+	}
+	return r
+}
+
+// appendHistory records an accepted line, deduping consecutive
+// repeats, and persists the capped history.
+func (r *lineReader) appendHistory(line string) {
+	if line == "" {
+		return
+	}
+	if n := len(r.history); n > 0 && r.history[n-1] == line {
+		return
+	}
+	r.history = append(r.history, line)
+	if len(r.history) > maxHistoryEntries {
+		r.history = r.history[len(r.history)-maxHistoryEntries:]
+	}
+	if err := os.MkdirAll(filepath.Dir(replHistoryPath), 0755); err != nil {
+		return
+	}
+	os.WriteFile(replHistoryPath, []byte(strings.Join(r.history, "\n")+"\n"), 0644)
+}
+
+// readLine reads one line, returning false on EOF.
+func (r *lineReader) readLine(prompt string) (string, bool) {
+	if !r.interactive {
+		fmt.Print(prompt)
+		if !r.scanner.Scan() {
+			return "", false
+		}
+		return r.scanner.Text(), true
+	}
+	return r.readLineRaw(prompt)
+}
+
+// readLineRaw runs a minimal line editor: insertion at a movable
+// cursor, backspace/delete, home/end, and history recall on up/down.
+func (r *lineReader) readLineRaw(prompt string) (string, bool) {
+	fd := os.Stdin.Fd()
+	old, err := getTermios(fd)
+	if err != nil {
+		fmt.Print(prompt)
+		if r.scanner == nil {
+			r.scanner = bufio.NewScanner(os.Stdin)
+		}
+		if !r.scanner.Scan() {
+			return "", false
+		}
+		return r.scanner.Text(), true
+	}
+	raw := *old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	if err := setTermios(fd, &raw); err != nil {
+		fmt.Print(prompt)
+		if r.scanner == nil {
+			r.scanner = bufio.NewScanner(os.Stdin)
+		}
+		if !r.scanner.Scan() {
+			return "", false
+		}
+		return r.scanner.Text(), true
+	}
+	defer setTermios(fd, old)
+
+	var line []rune
+	cursor := 0
+	histIdx := len(r.history)
+	saved := "" // the in-progress line while browsing history
+
+	redraw := func() {
+		fmt.Printf("\r\x1b[K%s%s", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Printf("\x1b[%dD", back)
+		}
+	}
+	redraw()
+
+	buf := make([]byte, 1)
+	var pending []byte // bytes of a UTF-8 sequence still being read
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		n, err := reader.Read(buf)
+		if err != nil || n == 0 {
+			fmt.Println()
+			return "", false
+		}
+		switch b := buf[0]; {
+		case b == '\r' || b == '\n':
+			fmt.Println()
+			return string(line), true
+		case b == 0x04: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Println()
+				return "", false
+			}
+		case b == 0x03: // Ctrl-C abandons the current line
+			fmt.Println("^C")
+			line = line[:0]
+			cursor = 0
+			histIdx = len(r.history)
+			redraw()
+		case b == 0x7f || b == 0x08: // backspace
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+				redraw()
+			}
+		case b == 0x01: // Ctrl-A
+			cursor = 0
+			redraw()
+		case b == 0x05: // Ctrl-E
+			cursor = len(line)
+			redraw()
+		case b == 0x1b: // escape sequence
+			seq, _ := reader.Peek(2)
+			if len(seq) < 2 || seq[0] != '[' {
+				continue
+			}
+			reader.Discard(2)
+			switch seq[1] {
+			case 'A': // up
+				if histIdx > 0 {
+					if histIdx == len(r.history) {
+						saved = string(line)
+					}
+					histIdx--
+					line = []rune(r.history[histIdx])
+					cursor = len(line)
+					redraw()
+				}
+			case 'B': // down
+				if histIdx < len(r.history) {
+					histIdx++
+					if histIdx == len(r.history) {
+						line = []rune(saved)
+					} else {
+						line = []rune(r.history[histIdx])
+					}
+					cursor = len(line)
+					redraw()
+				}
+			case 'C': // right
+				if cursor < len(line) {
+					cursor++
+					redraw()
+				}
+			case 'D': // left
+				if cursor > 0 {
+					cursor--
+					redraw()
+				}
+			case 'H': // home
+				cursor = 0
+				redraw()
+			case 'F': // end
+				cursor = len(line)
+				redraw()
+			case '3': // delete: ESC [ 3 ~
+				if next, _ := reader.Peek(1); len(next) == 1 && next[0] == '~' {
+					reader.Discard(1)
+				}
+				if cursor < len(line) {
+					line = append(line[:cursor], line[cursor+1:]...)
+					redraw()
+				}
+			}
+		case b >= 0x20: // printable; multi-byte runes arrive one byte at a time
+			pending = append(pending, b)
+			if !utf8.FullRune(pending) {
+				if len(pending) < utf8.UTFMax {
+					continue
+				}
+				pending = pending[:0] // over-long garbage, drop it
+				continue
+			}
+			ch, _ := utf8.DecodeRune(pending)
+			pending = pending[:0]
+			if ch == utf8.RuneError {
+				continue
+			}
+			line = append(line[:cursor], append([]rune{ch}, line[cursor:]...)...)
+			cursor++
+			redraw()
+		}
+	}
+}
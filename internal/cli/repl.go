@@ -0,0 +1,458 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aid/agentic/internal/bundle"
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/policy"
+	"github.com/aid/agentic/internal/token"
+	"github.com/aid/agentic/internal/workspace"
+)
+
+// currentNode is the REPL's focused node; commands without an explicit
+// node argument operate on it.
+var currentNode string
+
+// replGraph caches the loaded graph for the REPL session so handlers
+// do not re-parse every NODE.meta.yaml on each command; the reload
+// verb clears it.
+var replGraph *graph.Graph
+
+// sessionGraph returns the cached session graph, loading it on first
+// use.
+func sessionGraph() (*graph.Graph, error) {
+	if replGraph != nil {
+		return replGraph, nil
+	}
+	g, err := graph.Load(graph.ManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	replGraph = g
+	return g, nil
+}
+
+// replParallel is the session's parallel-execution setting, applied to
+// every task sent from the REPL.
+var replParallel bool
+
+// replParallelToggle shows or sets the session parallel mode.
+func replParallelToggle(arg string) error {
+	switch arg {
+	case "":
+		state := "off"
+		if replParallel {
+			state = "on"
+		}
+		fmt.Printf("Parallel mode is %s.\n", state)
+	case "on":
+		replParallel = true
+		fmt.Println("Parallel mode on.")
+	case "off":
+		replParallel = false
+		fmt.Println("Parallel mode off.")
+	default:
+		return fmt.Errorf("usage: parallel [on|off]")
+	}
+	return nil
+}
+
+// replRunTask previews which nodes a task would touch, confirms, and
+// runs it with the session's parallel setting.
+func replRunTask(request string) error {
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	nodes, err := selectNodes(g, currentNode)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID
+	}
+	fmt.Printf("Plan: %s\n", strings.Join(ids, " -> "))
+	if len(nodes) > 1 && !confirm(fmt.Sprintf("Run task on %d node(s)?", len(nodes))) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+	return runTask(request, currentNode, replParallel)
+}
+
+// replReload re-reads the manifest and node metadata from disk.
+func replReload() error {
+	replGraph = nil
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reloaded graph: %d nodes.\n", len(g.Nodes))
+	return nil
+}
+
+// StartREPL runs the interactive loop. Unrecognized input is treated
+// as a task request for the current node.
+func StartREPL() error {
+	fmt.Println("agentic interactive mode. Type 'help' for commands, 'exit' to quit.")
+
+	reader := newLineReader()
+	for {
+		prompt := "agentic> "
+		if currentNode != "" {
+			prompt = fmt.Sprintf("agentic[%s]> ", currentNode)
+		}
+		raw, ok := reader.readLine(prompt)
+		if !ok {
+			break
+		}
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		reader.appendHistory(line)
+
+		verb, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+
+		var err error
+		switch verb {
+		case "exit", "quit":
+			return nil
+		case "help":
+			printREPLHelp()
+		case "focus", "enter":
+			err = runEnter(rest)
+		case "graph":
+			err = runGraph()
+		case "nodes":
+			err = listNodes()
+		case "info":
+			err = showNodeInfo(rest)
+		case "bundle":
+			err = showBundle(rest)
+		case "checks":
+			err = runNodeChecks(rest)
+		case "status":
+			err = runStatus()
+		case "diff":
+			err = replDiff(rest)
+		case "unstage":
+			err = replUnstage(rest)
+		case "reset", "discard":
+			err = runReset(rest)
+		case "apply", "commit":
+			err = replApply(rest)
+		case "contracts":
+			err = replContracts(rest)
+		case "reload":
+			err = replReload()
+		case "undo":
+			err = replUndo()
+		case "redo":
+			err = replRedo()
+		case "parallel":
+			err = replParallelToggle(rest)
+		default:
+			// Anything else is a task request for the focused node.
+			err = replRunTask(line)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	return nil
+}
+
+func printREPLHelp() {
+	fmt.Println(`Commands:
+  focus <node>    set the current node (alias: enter)
+  graph           show the node graph
+  nodes           list all nodes
+  info <node>     show node details
+  bundle <node>   show bundle summary for a node
+  checks <node>   run a node's checks
+  status          show workspace status
+  diff [node]     show staged changes
+  unstage [node] <path>  drop one staged file (defaults to current node)
+  reset [node]    discard staged changes without applying (alias: discard)
+  apply           apply staged changes, running node checks (alias: commit)
+  apply nochecks  apply without running node checks
+  contracts update [node]  refresh the contract baseline
+  contracts check          report contract drift since the baseline
+  parallel [on|off]  show or set parallel task execution for this session
+  reload          re-read the graph and node metadata from disk
+  undo            revert the last apply
+  redo            reapply the last undone changes
+  exit            leave the REPL
+
+Anything else is sent to the brain as a task for the current node.`)
+}
+
+// runEnter focuses a node for subsequent commands.
+func runEnter(nodeID string) error {
+	if nodeID == "" {
+		currentNode = ""
+		fmt.Println("Cleared current node.")
+		return nil
+	}
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+	currentNode = nodeID
+	fmt.Printf("Focused on %s (%s)\n", node.ID, node.Type)
+	if node.Type == graph.NodeTypeComposite {
+		manifest := filepath.Join(node.Path, graph.ManifestFileName)
+		if sub, err := graph.Load(manifest); err == nil {
+			fmt.Printf("Subgraph %s: %d child node(s)\n", manifest, len(sub.Nodes))
+		} else {
+			fmt.Printf("No nested %s; bundling composite as raw files\n", manifest)
+		}
+	}
+	return nil
+}
+
+// listNodes prints all nodes with their type and budget.
+func listNodes() error {
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		marker := "L"
+		if node.Type == graph.NodeTypeComposite {
+			marker = "C"
+		}
+		fmt.Printf("  %s %-12s toks=%-6d deps=[%s]\n",
+			marker, node.ID, node.TokenCap, strings.Join(node.Dependencies, ","))
+	}
+	return nil
+}
+
+// showNodeInfo prints a node's metadata in full.
+func showNodeInfo(nodeID string) error {
+	if nodeID == "" {
+		nodeID = currentNode
+	}
+	if nodeID == "" {
+		return fmt.Errorf("no node specified and none focused")
+	}
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+
+	fmt.Printf("Node: %s\nType: %s\nPath: %s\nTokenCap: %d\nVersion: %d\n",
+		node.ID, node.Type, node.Path, node.TokenCap, node.Version)
+	fmt.Printf("Dependencies: [%s]\n", strings.Join(node.Dependencies, ", "))
+	deps := make([]string, 0, len(node.Dependents))
+	for _, d := range node.Dependents {
+		deps = append(deps, d.ID)
+	}
+	sort.Strings(deps)
+	fmt.Printf("Dependents: [%s]\n", strings.Join(deps, ", "))
+
+	if node.Meta != nil {
+		if node.Meta.Purpose != "" {
+			fmt.Printf("Purpose: %s\n", node.Meta.Purpose)
+		}
+		for _, inv := range node.Meta.Invariants {
+			fmt.Printf("  invariant: %s\n", inv)
+		}
+		for _, ng := range node.Meta.NonGoals {
+			fmt.Printf("  non-goal: %s\n", ng)
+		}
+	}
+	return nil
+}
+
+// showBundle builds and summarizes a node's bundle.
+func showBundle(nodeID string) error {
+	if nodeID == "" {
+		nodeID = currentNode
+	}
+	if nodeID == "" {
+		return fmt.Errorf("no node specified and none focused")
+	}
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+	b, err := bundle.Build(g, node)
+	if err != nil {
+		return err
+	}
+	verboseBundle(b)
+	budget := token.GetBudget("default")
+	fmt.Printf("Budget: %d available (%s)\n", budget.Available(), budget.Model)
+	return nil
+}
+
+// runNodeChecks runs the configured checks for a node.
+func runNodeChecks(nodeID string) error {
+	if nodeID == "" {
+		nodeID = currentNode
+	}
+	if nodeID == "" {
+		return fmt.Errorf("no node specified and none focused")
+	}
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	node, ok := g.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %q not found", nodeID)
+	}
+	if err := policy.RunChecks(node, nil, flagVerbose); err != nil {
+		return err
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+// replDiff shows staged changes, optionally for one node.
+func replDiff(nodeID string) error {
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	ws.PrintDiff(nodeID)
+	return nil
+}
+
+// replContracts dispatches the contracts verb: "update [node]" or
+// "check". A bare update with a focused node updates just that node.
+func replContracts(rest string) error {
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return fmt.Errorf("usage: contracts update [node] | contracts check")
+	}
+	switch fields[0] {
+	case "update":
+		nodeID := currentNode
+		if len(fields) > 1 {
+			nodeID = fields[1]
+		}
+		return runContractsUpdate(nodeID)
+	case "check":
+		return runContractsCheck()
+	default:
+		return fmt.Errorf("usage: contracts update [node] | contracts check")
+	}
+}
+
+// replUnstage drops one staged file, defaulting to the focused node
+// when only a path is given.
+func replUnstage(rest string) error {
+	fields := strings.Fields(rest)
+	var nodeID, path string
+	switch len(fields) {
+	case 1:
+		nodeID, path = currentNode, fields[0]
+		if nodeID == "" {
+			return fmt.Errorf("no node specified and none focused")
+		}
+	case 2:
+		nodeID, path = fields[0], fields[1]
+	default:
+		return fmt.Errorf("usage: unstage [node] <path>")
+	}
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	if err := ws.UnstageFile(nodeID, path); err != nil {
+		return err
+	}
+	if err := ws.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Unstaged %s from node %s.\n", path, nodeID)
+	return nil
+}
+
+// replApply applies staged changes after showing them. Node checks
+// run as part of the apply, matching the CLI path; "apply nochecks"
+// skips them like apply --skip-checks.
+func replApply(arg string) error {
+	skipChecks := false
+	switch strings.TrimSpace(arg) {
+	case "":
+	case "nochecks", "--skip-checks":
+		skipChecks = true
+	default:
+		return fmt.Errorf("usage: apply [nochecks]")
+	}
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	staged := ws.StagedNodes()
+	if len(staged) == 0 {
+		fmt.Println("No staged changes.")
+		return nil
+	}
+	ws.PrintDiff("")
+	if !confirm("Apply these changes?") {
+		fmt.Println("Aborted.")
+		return nil
+	}
+	g, err := sessionGraph()
+	if err != nil {
+		return err
+	}
+	written, err := ws.ApplyChanges(workspace.ApplyOptions{
+		Atomic:   true,
+		Validate: checkValidator(g, skipChecks),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d file(s).\n", len(written))
+	return nil
+}
+
+// replUndo reverts the last apply.
+func replUndo() error {
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	if err := ws.Undo(false); err != nil {
+		return err
+	}
+	fmt.Println("Reverted last apply.")
+	return nil
+}
+
+// replRedo reapplies the last undone changes.
+func replRedo() error {
+	ws, err := workspace.Load()
+	if err != nil {
+		return err
+	}
+	if err := ws.Redo(); err != nil {
+		return err
+	}
+	fmt.Println("Reapplied last undone changes.")
+	return nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultFilePath is where LoadFile looks when no --config flag names
+// a file.
+const DefaultFilePath = ".agentic/config.yaml"
+
+// FileConfig holds the persistent agentic CLI settings a project can
+// set in its config file. The format is the same minimal YAML subset
+// the graph package uses for node metadata: flat "key: value" lines
+// with # comments. Flags and environment variables always override
+// these values.
+type FileConfig struct {
+	// Brain is the default brain adapter, used when neither --brain
+	// nor AGENTIC_BRAIN selects one.
+	Brain string
+	// TokenBudget overrides the default model context window size.
+	TokenBudget int
+	// CheckTimeout is the default per-check timeout as a Go duration
+	// string, e.g. "90s".
+	CheckTimeout string
+	// Estimator selects the token estimation algorithm: "heuristic"
+	// (default) or "bpe".
+	Estimator string
+	// Parallel makes run process independent nodes in parallel by
+	// default.
+	Parallel bool
+	// MaxParallel caps the number of concurrent brain calls in a
+	// parallel run.
+	MaxParallel int
+	// SkipChecks disables node checks during apply by default.
+	SkipChecks bool
+	// Budgets adds or overrides model token budgets, keyed by model
+	// name.
+	Budgets map[string]BudgetSpec
+}
+
+// BudgetSpec is one model's context window in a budgets: section.
+type BudgetSpec struct {
+	MaxContext     int
+	ReservedOutput int
+}
+
+// LoadFile reads the config at path, or DefaultFilePath when path is
+// empty. A missing default config is not an error; an explicitly
+// named file must exist.
+func LoadFile(path string) (*FileConfig, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultFilePath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &FileConfig{}, nil
+		}
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+	return parseFile(path, string(data))
+}
+
+// parseFile interprets the key: value lines of a config file. Most
+// keys are flat; the budgets: section nests one model per entry.
+func parseFile(path, data string) (*FileConfig, error) {
+	cfg := &FileConfig{}
+	var section, model string
+	for lineNo, raw := range strings.Split(data, "\n") {
+		line := raw
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected key: value, got %q", path, lineNo+1, line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		if indent > 0 {
+			if section != "budgets" {
+				return nil, fmt.Errorf("%s:%d: unexpected indented key %q", path, lineNo+1, key)
+			}
+			if value == "" {
+				model = key
+				continue
+			}
+			if model == "" {
+				return nil, fmt.Errorf("%s:%d: budget field %q outside a model entry", path, lineNo+1, key)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid %s %q", path, lineNo+1, key, value)
+			}
+			if cfg.Budgets == nil {
+				cfg.Budgets = make(map[string]BudgetSpec)
+			}
+			spec := cfg.Budgets[model]
+			switch key {
+			case "max_context":
+				spec.MaxContext = n
+			case "reserved_output":
+				spec.ReservedOutput = n
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown budget field %q", path, lineNo+1, key)
+			}
+			cfg.Budgets[model] = spec
+			continue
+		}
+		section, model = "", ""
+		if key == "budgets" && value == "" {
+			section = "budgets"
+			continue
+		}
+
+		switch key {
+		case "brain":
+			cfg.Brain = value
+		case "token_budget":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid token_budget %q", path, lineNo+1, value)
+			}
+			cfg.TokenBudget = n
+		case "check_timeout":
+			cfg.CheckTimeout = value
+		case "estimator":
+			cfg.Estimator = value
+		case "parallel":
+			cfg.Parallel = value == "true"
+		case "max_parallel":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid max_parallel %q", path, lineNo+1, value)
+			}
+			cfg.MaxParallel = n
+		case "skip_checks":
+			cfg.SkipChecks = value == "true"
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown config key %q", path, lineNo+1, key)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,1257 @@
+// Package graph implements the graph engine: GRAPH.manifest parsing,
+// NODE.meta.yaml loading, dependency resolution, and DAG operations.
+//
+// The manifest format is deliberately compact, one line per node:
+//
+//	L:token  path=nodes/token  deps=[]            toks=3000 ver=1
+//	C:api    path=nodes/api    deps=[core,store]  toks=12000 ver=1
+//
+// L: marks a leaf node, C: a composite node carrying its own subgraph.
+package graph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NodeType distinguishes leaf nodes from composite (subgraph) nodes.
+type NodeType string
+
+const (
+	NodeTypeLeaf      NodeType = "leaf"
+	NodeTypeComposite NodeType = "composite"
+)
+
+// MetaFileName is the per-node metadata file name.
+const MetaFileName = "NODE.meta.yaml"
+
+// ManifestFileName is the default graph manifest file name.
+const ManifestFileName = "GRAPH.manifest"
+
+// Node is a single node in the graph.
+type Node struct {
+	ID           string
+	Type         NodeType
+	Path         string
+	Dependencies []string
+	Dependents   []*Node
+	TokenCap     int
+	Version      int
+	Tags         []string
+	Meta         *NodeMeta
+}
+
+// HasTag reports whether the node carries the given tag.
+func (n *Node) HasTag(tag string) bool {
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeMeta holds the optional NODE.meta.yaml metadata for a node.
+type NodeMeta struct {
+	ID         string   `json:"id,omitempty"`
+	Type       string   `json:"type,omitempty"`
+	Purpose    string   `json:"purpose,omitempty"`
+	Invariants []string `json:"invariants,omitempty"`
+	NonGoals   []string `json:"non_goals,omitempty"`
+	// AlwaysInclude pins files (relative to the node path) into the
+	// bundle even when extension or size filters would exclude them.
+	AlwaysInclude []string `json:"always_include,omitempty"`
+	// ReviewRequired makes apply refuse this node's staged changes,
+	// even with --yes, until acknowledged with --review-confirmed.
+	ReviewRequired bool         `json:"review_required,omitempty"`
+	Budgets        MetaBudgets  `json:"budgets"`
+	Policies       MetaPolicies `json:"policies"`
+}
+
+// BudgetScopeSource limits the token cap to the node's own source
+// files, excluding dependency contracts.
+const BudgetScopeSource = "source"
+
+// MetaBudgets holds budget settings from NODE.meta.yaml.
+type MetaBudgets struct {
+	TokenCap int `json:"token_cap,omitempty"`
+	// MaxFileSize overrides the bundle's per-file size limit, in bytes.
+	// Zero means use the default.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// Scope selects what the token cap counts: empty counts everything,
+	// "source" counts only the node's own files.
+	Scope string `json:"scope,omitempty"`
+	// CountExt restricts which file extensions count toward the token
+	// cap (e.g. only ".go"); empty counts every file. Filtered files
+	// still appear in the bundle.
+	CountExt []string `json:"count_ext,omitempty"`
+}
+
+// MetaPolicies holds policy settings from NODE.meta.yaml.
+type MetaPolicies struct {
+	AllowedPaths []string `json:"allowed_paths,omitempty"`
+	DeniedPaths  []string `json:"denied_paths,omitempty"`
+	Checks       []string `json:"checks,omitempty"`
+	// AllowNewDirs lets the scope check accept new files under an
+	// allowed root even when no pattern matches them exactly.
+	AllowNewDirs bool `json:"allow_new_dirs,omitempty"`
+	// ForbiddenImports lists import paths staged Go files must not
+	// use; a trailing "/..." blocks the whole subtree.
+	ForbiddenImports []string `json:"forbidden_imports,omitempty"`
+	// MaxFiles caps how many files the node may hold after staged
+	// changes; zero means no limit. MaxFilesSeverity selects whether
+	// exceeding it is a "warning" (default) or an "error".
+	MaxFiles         int    `json:"max_files,omitempty"`
+	MaxFilesSeverity string `json:"max_files_severity,omitempty"`
+	// OutputFiles, when set, is the exact whitelist of files the node
+	// may stage; anything else, including new files, is rejected.
+	// Stricter than AllowedPaths: the layout is fixed, only content
+	// may change.
+	OutputFiles []string `json:"output_files,omitempty"`
+	// Severity overrides the default severity per policy id, e.g.
+	// contract_change: error, token_budget: warning.
+	Severity map[string]string `json:"severity,omitempty"`
+	// RequireHeader makes staged Go files start with this header: a
+	// literal comment or the path of a header template file.
+	RequireHeader string `json:"require_header,omitempty"`
+	// HeaderIgnore lists path patterns exempt from the header check,
+	// e.g. generated files.
+	HeaderIgnore []string `json:"header_ignore,omitempty"`
+	// CheckTimeout bounds how long each check command may run, as a Go
+	// duration string like "90s"; empty uses the default.
+	CheckTimeout string `json:"check_timeout,omitempty"`
+}
+
+// Graph is a parsed manifest with resolved nodes.
+type Graph struct {
+	Path  string
+	Nodes map[string]*Node
+	Order []string
+}
+
+// Load reads and validates a GRAPH.manifest, loading each node's
+// NODE.meta.yaml when present.
+func Load(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening manifest: %w", err)
+	}
+	defer f.Close()
+
+	g := &Graph{
+		Path:  path,
+		Nodes: make(map[string]*Node),
+	}
+
+	baseDir := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		node, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("manifest line %d: %w", lineNo, err)
+		}
+		if _, exists := g.Nodes[node.ID]; exists {
+			return nil, fmt.Errorf("manifest line %d: duplicate node id %q", lineNo, node.ID)
+		}
+		g.Nodes[node.ID] = node
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	for _, node := range g.Nodes {
+		if err := loadNodeMeta(baseDir, node); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	g.linkDependents()
+
+	order, err := g.TopologicalSort()
+	if err != nil {
+		return nil, err
+	}
+	g.Order = order
+
+	return g, nil
+}
+
+// parseLine parses one manifest node line.
+func parseLine(line string) (*Node, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed node line: %q", line)
+	}
+
+	head := fields[0]
+	sep := strings.Index(head, ":")
+	if sep < 0 {
+		return nil, fmt.Errorf("missing type prefix in %q", head)
+	}
+	node := &Node{
+		ID:      strings.TrimSpace(head[sep+1:]),
+		Version: 1,
+	}
+	switch head[:sep] {
+	case "L":
+		node.Type = NodeTypeLeaf
+	case "C":
+		node.Type = NodeTypeComposite
+	default:
+		return nil, fmt.Errorf("unknown node type %q", head[:sep])
+	}
+	if node.ID == "" {
+		return nil, fmt.Errorf("empty node id in %q", line)
+	}
+
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed field %q", field)
+		}
+		switch key {
+		case "path":
+			node.Path = value
+		case "deps":
+			value = strings.TrimPrefix(value, "[")
+			value = strings.TrimSuffix(value, "]")
+			if value != "" {
+				node.Dependencies = strings.Split(value, ",")
+			}
+		case "toks":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid toks value %q: %w", value, err)
+			}
+			node.TokenCap = n
+		case "ver":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ver value %q: %w", value, err)
+			}
+			node.Version = n
+		case "tags":
+			value = strings.TrimPrefix(value, "[")
+			value = strings.TrimSuffix(value, "]")
+			if value != "" {
+				node.Tags = strings.Split(value, ",")
+			}
+		default:
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+	}
+
+	if node.Path == "" {
+		return nil, fmt.Errorf("node %q has no path", node.ID)
+	}
+	return node, nil
+}
+
+// formatNode renders a node back into manifest line form.
+func formatNode(node *Node) string {
+	prefix := "L"
+	if node.Type == NodeTypeComposite {
+		prefix = "C"
+	}
+	deps := strings.Join(node.Dependencies, ",")
+	line := fmt.Sprintf("%s:%s path=%s deps=[%s] toks=%d ver=%d",
+		prefix, node.ID, node.Path, deps, node.TokenCap, node.Version)
+	if len(node.Tags) > 0 {
+		line += fmt.Sprintf(" tags=[%s]", strings.Join(node.Tags, ","))
+	}
+	return line
+}
+
+// Save rewrites the manifest file from the in-memory graph, preserving
+// topological order.
+func (g *Graph) Save() error {
+	return writeManifest(g.Path, g)
+}
+
+// MergeNodes folds the source nodes into target: dependencies are
+// unioned, token caps summed, and every dependent of a source is
+// rewired to depend on target instead. The sources are removed from
+// the graph. The merge is rejected if it would create a cycle; the
+// graph is left untouched in that case. Source SRC directories are
+// not moved on disk — that remains a manual step.
+func (g *Graph) MergeNodes(targetID string, sourceIDs []string) error {
+	target, ok := g.Nodes[targetID]
+	if !ok {
+		return fmt.Errorf("target node %q not found", targetID)
+	}
+	merged := map[string]bool{targetID: true}
+	for _, id := range sourceIDs {
+		src, ok := g.Nodes[id]
+		if !ok {
+			return fmt.Errorf("source node %q not found", id)
+		}
+		if id == targetID {
+			return fmt.Errorf("cannot merge node %q into itself", id)
+		}
+		if src.Type == NodeTypeComposite {
+			return fmt.Errorf("node %q is composite; only leaf nodes can be merged", id)
+		}
+		merged[id] = true
+	}
+
+	// Stage the new dependency lists without touching the graph, so a
+	// cycle leaves everything intact.
+	newDeps := make(map[string][]string)
+	union := append([]string(nil), target.Dependencies...)
+	for _, id := range sourceIDs {
+		union = append(union, g.Nodes[id].Dependencies...)
+	}
+	newDeps[targetID] = dedupeDeps(union, merged)
+
+	for id, node := range g.Nodes {
+		if merged[id] {
+			continue
+		}
+		touched := false
+		deps := append([]string(nil), node.Dependencies...)
+		for i, dep := range deps {
+			if merged[dep] && dep != targetID {
+				deps[i] = targetID
+				touched = true
+			}
+		}
+		if touched {
+			newDeps[id] = dedupeDeps(deps, map[string]bool{id: true})
+		}
+	}
+
+	trial := &Graph{Path: g.Path, Nodes: make(map[string]*Node)}
+	for id, node := range g.Nodes {
+		if merged[id] && id != targetID {
+			continue
+		}
+		copied := *node
+		copied.Dependents = nil
+		if deps, ok := newDeps[id]; ok {
+			copied.Dependencies = deps
+		}
+		trial.Nodes[id] = &copied
+	}
+	order, err := trial.TopologicalSort()
+	if err != nil {
+		return fmt.Errorf("merge would break the graph: %w", err)
+	}
+
+	// Commit: adopt the rewired nodes and drop the sources.
+	for _, id := range sourceIDs {
+		target.TokenCap += g.Nodes[id].TokenCap
+		delete(g.Nodes, id)
+	}
+	for id, deps := range newDeps {
+		g.Nodes[id].Dependencies = deps
+	}
+	for _, node := range g.Nodes {
+		node.Dependents = nil
+	}
+	g.linkDependents()
+	g.Order = order
+	return nil
+}
+
+// dedupeDeps removes duplicates and excluded ids from a dependency
+// list, preserving first-seen order.
+func dedupeDeps(deps []string, exclude map[string]bool) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		if seen[dep] || exclude[dep] {
+			continue
+		}
+		seen[dep] = true
+		out = append(out, dep)
+	}
+	return out
+}
+
+// writeManifest writes a graph to a manifest file.
+func writeManifest(path string, g *Graph) error {
+	var sb strings.Builder
+	sb.WriteString("# Agentic Graph Manifest - self-describing structure\n")
+	sb.WriteString("# Format: TYPE:ID path=PATH deps=[DEPS] toks=TOKEN_CAP ver=VERSION\n\n")
+	order := g.Order
+	if len(order) == 0 {
+		order = sortedNodeIDs(g.Nodes)
+	}
+	for _, id := range order {
+		node, ok := g.Nodes[id]
+		if !ok {
+			continue
+		}
+		sb.WriteString(formatNode(node))
+		sb.WriteString("\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// loadNodeMeta loads the node's NODE.meta.yaml if present. A missing
+// meta file is not an error: metadata is optional.
+func loadNodeMeta(baseDir string, node *Node) error {
+	metaPath := filepath.Join(baseDir, node.Path, MetaFileName)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading %s: %w", metaPath, err)
+	}
+	meta, err := parseNodeMeta(data)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", metaPath, err)
+	}
+	// A stale id from a copy-pasted NODE.meta.yaml silently attaches
+	// the wrong metadata; catch the disagreement at load time.
+	if meta.ID != "" && meta.ID != node.ID {
+		return fmt.Errorf("%s declares id %q but the manifest names this node %q", metaPath, meta.ID, node.ID)
+	}
+	node.Meta = meta
+	if node.TokenCap == 0 && meta.Budgets.TokenCap > 0 {
+		node.TokenCap = meta.Budgets.TokenCap
+	}
+	return nil
+}
+
+// parseNodeMeta parses the minimal YAML subset used by NODE.meta.yaml:
+// top-level scalars, string lists, and one level of nested maps. We
+// parse by hand to keep the tool dependency-free, mirroring the compact
+// manifest philosophy.
+func parseNodeMeta(data []byte) (*NodeMeta, error) {
+	meta := &NodeMeta{}
+
+	// section tracks the current top-level key ("budgets", "policies"),
+	// list tracks the current list key within that section. subMap
+	// tracks a nested map key inside a section (policies.severity),
+	// with subMapIndent marking where its children must sit.
+	var section, list, subMap string
+	var subMapIndent int
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := scanner.Text()
+		line := strings.TrimRight(raw, " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		trimmed := strings.TrimSpace(line)
+
+		// List item.
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			value := unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err := meta.appendList(section, list, value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: malformed line %q", lineNo, trimmed)
+		}
+		key = strings.TrimSpace(key)
+		value = unquote(strings.TrimSpace(value))
+
+		// Entry in a nested map (policies.severity).
+		if subMap != "" && indent > subMapIndent {
+			if section == "policies" && subMap == "severity" {
+				if meta.Policies.Severity == nil {
+					meta.Policies.Severity = make(map[string]string)
+				}
+				meta.Policies.Severity[key] = value
+			}
+			continue
+		}
+		subMap = ""
+
+		if indent == 0 {
+			section = ""
+			list = ""
+			switch key {
+			case "id":
+				meta.ID = value
+			case "type":
+				meta.Type = value
+			case "purpose":
+				meta.Purpose = value
+			case "budget_scope":
+				meta.Budgets.Scope = value
+			case "review_required":
+				meta.ReviewRequired = value == "true"
+			case "invariants", "non_goals", "always_include", "budget_count_ext":
+				list = key
+			case "budgets", "policies":
+				section = key
+			default:
+				// Unknown top-level keys are ignored for forward compatibility.
+			}
+			continue
+		}
+
+		// Nested key inside a section.
+		list = ""
+		switch section {
+		case "budgets":
+			switch key {
+			case "token_cap":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid token_cap %q", lineNo, value)
+				}
+				meta.Budgets.TokenCap = n
+			case "max_file_size":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid max_file_size %q", lineNo, value)
+				}
+				meta.Budgets.MaxFileSize = n
+			case "scope":
+				meta.Budgets.Scope = value
+			case "count_ext":
+				list = key
+			}
+		case "policies":
+			switch key {
+			case "allowed_paths", "denied_paths", "checks", "forbidden_imports", "output_files", "header_ignore":
+				list = key
+			case "allow_new_dirs":
+				meta.Policies.AllowNewDirs = value == "true"
+			case "max_files":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid max_files %q", lineNo, value)
+				}
+				meta.Policies.MaxFiles = n
+			case "max_files_severity":
+				meta.Policies.MaxFilesSeverity = value
+			case "require_header":
+				meta.Policies.RequireHeader = value
+			case "check_timeout":
+				meta.Policies.CheckTimeout = value
+			case "severity":
+				subMap = key
+				subMapIndent = indent
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// appendList appends a parsed list item to the right NodeMeta field.
+func (m *NodeMeta) appendList(section, list, value string) error {
+	switch {
+	case section == "" && list == "invariants":
+		m.Invariants = append(m.Invariants, value)
+	case section == "" && list == "non_goals":
+		m.NonGoals = append(m.NonGoals, value)
+	case section == "" && list == "always_include":
+		m.AlwaysInclude = append(m.AlwaysInclude, value)
+	case section == "" && list == "budget_count_ext", section == "budgets" && list == "count_ext":
+		m.Budgets.CountExt = append(m.Budgets.CountExt, value)
+	case section == "policies" && list == "allowed_paths":
+		m.Policies.AllowedPaths = append(m.Policies.AllowedPaths, value)
+	case section == "policies" && list == "denied_paths":
+		m.Policies.DeniedPaths = append(m.Policies.DeniedPaths, value)
+	case section == "policies" && list == "checks":
+		m.Policies.Checks = append(m.Policies.Checks, value)
+	case section == "policies" && list == "forbidden_imports":
+		m.Policies.ForbiddenImports = append(m.Policies.ForbiddenImports, value)
+	case section == "policies" && list == "output_files":
+		m.Policies.OutputFiles = append(m.Policies.OutputFiles, value)
+	case section == "policies" && list == "header_ignore":
+		m.Policies.HeaderIgnore = append(m.Policies.HeaderIgnore, value)
+	default:
+		// List items under unknown keys are ignored.
+	}
+	return nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// validate checks referential integrity and rejects cycles.
+func (g *Graph) validate() error {
+	for _, node := range g.Nodes {
+		for _, dep := range node.Dependencies {
+			if _, ok := g.Nodes[dep]; !ok {
+				return fmt.Errorf("node %q depends on unknown node %q", node.ID, dep)
+			}
+		}
+	}
+
+	if path := findCycle(g.Nodes, sortedNodeIDs(g.Nodes)); path != "" {
+		return fmt.Errorf("cycle detected: %s", path)
+	}
+	return nil
+}
+
+// findCycle runs a DFS over the given node IDs and, if a dependency
+// cycle exists, returns it as a readable chain like "a -> b -> c -> a".
+// It returns "" for acyclic graphs.
+func findCycle(nodes map[string]*Node, ids []string) string {
+	const (
+		white = 0
+		gray  = 1
+		black = 2
+	)
+	color := make(map[string]int, len(nodes))
+	var stack []string
+	var found string
+
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		color[id] = gray
+		stack = append(stack, id)
+		for _, dep := range nodes[id].Dependencies {
+			if _, ok := nodes[dep]; !ok {
+				continue
+			}
+			switch color[dep] {
+			case gray:
+				// The cycle is the stack from dep's first occurrence,
+				// closed back on dep.
+				start := 0
+				for i, s := range stack {
+					if s == dep {
+						start = i
+						break
+					}
+				}
+				found = strings.Join(append(append([]string(nil), stack[start:]...), dep), " -> ")
+				return true
+			case white:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[id] = black
+		return false
+	}
+	for _, id := range ids {
+		if color[id] == white && visit(id) {
+			return found
+		}
+	}
+	return ""
+}
+
+// linkDependents populates each node's Dependents from the dependency
+// lists, sorted for determinism.
+func (g *Graph) linkDependents() {
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		node := g.Nodes[id]
+		for _, dep := range node.Dependencies {
+			target := g.Nodes[dep]
+			target.Dependents = append(target.Dependents, node)
+		}
+	}
+}
+
+// TopologicalSort returns node IDs with dependencies before dependents.
+// Ties are broken alphabetically so the order is deterministic.
+func (g *Graph) TopologicalSort() ([]string, error) {
+	inDegree := make(map[string]int, len(g.Nodes))
+	for id := range g.Nodes {
+		inDegree[id] = len(g.Nodes[id].Dependencies)
+	}
+
+	var ready []string
+	for id, deg := range inDegree {
+		if deg == 0 {
+			ready = append(ready, id)
+		}
+	}
+	sort.Strings(ready)
+
+	var order []string
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+
+		var next []string
+		for _, dependent := range g.Nodes[id].Dependents {
+			inDegree[dependent.ID]--
+			if inDegree[dependent.ID] == 0 {
+				next = append(next, dependent.ID)
+			}
+		}
+		sort.Strings(next)
+		ready = mergeSorted(ready, next)
+	}
+
+	if len(order) != len(g.Nodes) {
+		if path := findCycle(g.Nodes, sortedNodeIDs(g.Nodes)); path != "" {
+			return nil, fmt.Errorf("cycle detected: %s", path)
+		}
+		return nil, fmt.Errorf("cycle detected involving node: %s", firstUnordered(g.Nodes, order))
+	}
+	return order, nil
+}
+
+// mergeSorted merges two sorted string slices.
+func mergeSorted(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] <= b[j] {
+			out = append(out, a[i])
+			i++
+		} else {
+			out = append(out, b[j])
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	out = append(out, b[j:]...)
+	return out
+}
+
+func firstUnordered(nodes map[string]*Node, order []string) string {
+	seen := make(map[string]bool, len(order))
+	for _, id := range order {
+		seen[id] = true
+	}
+	for _, id := range sortedNodeIDs(nodes) {
+		if !seen[id] {
+			return id
+		}
+	}
+	return "?"
+}
+
+// GetReverseDeps returns all transitive dependents of a node (everything
+// that would be affected by changing it), deduplicated.
+func (g *Graph) GetReverseDeps(nodeID string) []*Node {
+	seen := make(map[string]bool)
+	var result []*Node
+	var walk func(id string)
+	walk = func(id string) {
+		node, ok := g.Nodes[id]
+		if !ok {
+			return
+		}
+		for _, dependent := range node.Dependents {
+			if seen[dependent.ID] {
+				continue
+			}
+			seen[dependent.ID] = true
+			result = append(result, dependent)
+			walk(dependent.ID)
+		}
+	}
+	walk(nodeID)
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// GetDependencyClosure returns all transitive dependencies of a node
+// (everything it relies on, directly or indirectly), deduplicated, in
+// topological order. The forward counterpart of GetReverseDeps. The
+// seen set guards against cycles even though validate rejects them.
+func (g *Graph) GetDependencyClosure(nodeID string) []*Node {
+	seen := make(map[string]bool)
+	var walk func(id string)
+	walk = func(id string) {
+		node, ok := g.Nodes[id]
+		if !ok {
+			return
+		}
+		for _, dep := range node.Dependencies {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			walk(dep)
+		}
+	}
+	walk(nodeID)
+
+	result := make([]*Node, 0, len(seen))
+	for _, id := range g.Order {
+		if seen[id] {
+			result = append(result, g.Nodes[id])
+		}
+	}
+	return result
+}
+
+// Verify checks the graph against the filesystem: every node's path
+// must exist and be a directory, and literal allowed_paths entries must
+// resolve under the node path. All problems are collected and returned
+// together rather than failing on the first.
+func (g *Graph) Verify() []error {
+	root := filepath.Dir(g.Path)
+	var problems []error
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		node := g.Nodes[id]
+		dir := filepath.Join(root, node.Path)
+		info, err := os.Stat(dir)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Errorf("node %s: path %s does not exist", id, node.Path))
+			continue
+		case !info.IsDir():
+			problems = append(problems, fmt.Errorf("node %s: path %s is not a directory", id, node.Path))
+			continue
+		}
+		if node.Meta == nil {
+			continue
+		}
+		for _, pattern := range node.Meta.Policies.AllowedPaths {
+			if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "..") {
+				problems = append(problems, fmt.Errorf("node %s: allowed path %q escapes the node path", id, pattern))
+				continue
+			}
+			if strings.ContainsAny(pattern, "*?[") {
+				// Glob patterns match future files; existence is not required.
+				continue
+			}
+			target := filepath.Join(dir, strings.TrimSuffix(pattern, "/"))
+			if _, err := os.Stat(target); err != nil {
+				problems = append(problems, fmt.Errorf("node %s: allowed path %q does not exist under %s", id, pattern, node.Path))
+			}
+		}
+	}
+	return problems
+}
+
+// FindOrphans returns nodes that nothing depends on and that depend on
+// nothing — usually leftovers from refactors.
+func (g *Graph) FindOrphans() []*Node {
+	var orphans []*Node
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		node := g.Nodes[id]
+		if len(node.Dependencies) == 0 && len(node.Dependents) == 0 {
+			orphans = append(orphans, node)
+		}
+	}
+	return orphans
+}
+
+// FindMissingPaths returns nodes whose path directory no longer exists
+// on disk, resolved relative to the manifest location.
+func (g *Graph) FindMissingPaths() []*Node {
+	root := filepath.Dir(g.Path)
+	var missing []*Node
+	for _, id := range sortedNodeIDs(g.Nodes) {
+		node := g.Nodes[id]
+		if info, err := os.Stat(filepath.Join(root, node.Path)); err != nil || !info.IsDir() {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
+
+// Print renders the graph as an ASCII tree rooted at nodes that have no
+// dependents. With describe, each node's purpose from NODE.meta.yaml is
+// shown alongside, truncated to one line.
+func (g *Graph) Print(describe bool) {
+	var roots []string
+	for _, id := range g.Order {
+		if len(g.Nodes[id].Dependents) == 0 {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+	visited := make(map[string]bool)
+	for _, id := range roots {
+		g.printNode(g.Nodes[id], 0, visited, describe)
+	}
+}
+
+// printNode prints one node and recurses into its dependencies.
+func (g *Graph) printNode(node *Node, depth int, visited map[string]bool, describe bool) {
+	indent := strings.Repeat("  ", depth)
+	marker := "L"
+	if node.Type == NodeTypeComposite {
+		marker = "C"
+	}
+	if visited[node.ID] {
+		fmt.Printf("%s%s %s (see above)\n", indent, marker, node.ID)
+		return
+	}
+	visited[node.ID] = true
+	desc := ""
+	if describe && node.Meta != nil && node.Meta.Purpose != "" {
+		desc = " - " + truncateLine(node.Meta.Purpose, 72)
+	}
+	fmt.Printf("%s%s %s (toks=%d)%s\n", indent, marker, node.ID, node.TokenCap, desc)
+	deps := append([]string(nil), node.Dependencies...)
+	sort.Strings(deps)
+	for _, dep := range deps {
+		g.printNode(g.Nodes[dep], depth+1, visited, describe)
+	}
+}
+
+// truncateLine reduces s to its first line, capped at max runes.
+func truncateLine(s string, max int) string {
+	if idx := strings.IndexByte(s, '\n'); idx >= 0 {
+		s = s[:idx]
+	}
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	return s
+}
+
+// ToDOT renders the graph as a Graphviz digraph. Leaf nodes are drawn
+// as ellipses, composite nodes as boxes; edges point from a node to
+// each of its dependencies. Output is deterministic: nodes are emitted
+// in g.Order and edges in manifest dependency order.
+func (g *Graph) ToDOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph agentic {\n")
+	sb.WriteString("  rankdir=BT;\n")
+	sb.WriteString("  node [fontname=\"Helvetica\"];\n\n")
+
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		shape := "ellipse"
+		fill := "lightblue"
+		if node.Type == NodeTypeComposite {
+			shape = "box"
+			fill = "lightyellow"
+		}
+		fmt.Fprintf(&sb, "  %q [shape=%s, style=filled, fillcolor=%s, label=\"%s\\n%d toks\"];\n",
+			id, shape, fill, id, node.TokenCap)
+	}
+	sb.WriteString("\n")
+	for _, id := range g.Order {
+		for _, dep := range g.Nodes[id].Dependencies {
+			fmt.Fprintf(&sb, "  %q -> %q [label=\"depends on\"];\n", id, dep)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// jsonNode is the serialized form of a Node. Dependents are emitted as
+// IDs rather than nested nodes to keep the output acyclic.
+type jsonNode struct {
+	ID           string    `json:"id"`
+	Type         NodeType  `json:"type"`
+	Path         string    `json:"path"`
+	TokenCap     int       `json:"token_cap"`
+	Version      int       `json:"version"`
+	Tags         []string  `json:"tags,omitempty"`
+	Dependencies []string  `json:"dependencies"`
+	Dependents   []string  `json:"dependents"`
+	Meta         *NodeMeta `json:"meta,omitempty"`
+}
+
+// ToJSON serializes the graph for external tooling: nodes in
+// topological order with their resolved metadata.
+func (g *Graph) ToJSON() ([]byte, error) {
+	nodes := make([]jsonNode, 0, len(g.Nodes))
+	for _, id := range g.Order {
+		node := g.Nodes[id]
+		dependents := make([]string, 0, len(node.Dependents))
+		for _, d := range node.Dependents {
+			dependents = append(dependents, d.ID)
+		}
+		sort.Strings(dependents)
+		deps := node.Dependencies
+		if deps == nil {
+			deps = []string{}
+		}
+		nodes = append(nodes, jsonNode{
+			ID:           node.ID,
+			Type:         node.Type,
+			Path:         node.Path,
+			TokenCap:     node.TokenCap,
+			Version:      node.Version,
+			Tags:         node.Tags,
+			Dependencies: deps,
+			Dependents:   dependents,
+			Meta:         node.Meta,
+		})
+	}
+	return json.MarshalIndent(map[string]interface{}{"nodes": nodes}, "", "  ")
+}
+
+// NodeDiff describes how one node differs between two manifests.
+type NodeDiff struct {
+	ID      string   `json:"id"`
+	OldDeps []string `json:"old_deps,omitempty"`
+	NewDeps []string `json:"new_deps,omitempty"`
+	OldToks int      `json:"old_toks,omitempty"`
+	NewToks int      `json:"new_toks,omitempty"`
+	OldVer  int      `json:"old_ver,omitempty"`
+	NewVer  int      `json:"new_ver,omitempty"`
+}
+
+// ManifestDiff summarizes the differences between two manifests.
+type ManifestDiff struct {
+	Added   []string   `json:"added"`
+	Removed []string   `json:"removed"`
+	Changed []NodeDiff `json:"changed"`
+}
+
+// Empty reports whether the diff contains no differences.
+func (d *ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffGraphs compares two loaded graphs and reports added and removed
+// nodes plus dependency, token-cap, and version changes for nodes
+// present in both.
+func DiffGraphs(old, new *Graph) *ManifestDiff {
+	diff := &ManifestDiff{Added: []string{}, Removed: []string{}}
+
+	for _, id := range sortedNodeIDs(new.Nodes) {
+		if _, ok := old.Nodes[id]; !ok {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+	for _, id := range sortedNodeIDs(old.Nodes) {
+		if _, ok := new.Nodes[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	for _, id := range sortedNodeIDs(old.Nodes) {
+		oldNode := old.Nodes[id]
+		newNode, ok := new.Nodes[id]
+		if !ok {
+			continue
+		}
+		nd := NodeDiff{ID: id}
+		changed := false
+		if !equalStrings(oldNode.Dependencies, newNode.Dependencies) {
+			nd.OldDeps, nd.NewDeps = oldNode.Dependencies, newNode.Dependencies
+			changed = true
+		}
+		if oldNode.TokenCap != newNode.TokenCap {
+			nd.OldToks, nd.NewToks = oldNode.TokenCap, newNode.TokenCap
+			changed = true
+		}
+		if oldNode.Version != newNode.Version {
+			nd.OldVer, nd.NewVer = oldNode.Version, newNode.Version
+			changed = true
+		}
+		if changed {
+			diff.Changed = append(diff.Changed, nd)
+		}
+	}
+	return diff
+}
+
+// equalStrings compares two string slices order-insensitively.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as := append([]string(nil), a...)
+	bs := append([]string(nil), b...)
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Discover scans a project tree for node directories (directories
+// containing NODE.meta.yaml) and builds a manifest from what it finds.
+// Dependencies are inferred from Go imports whose final element matches
+// another discovered node's ID.
+func Discover(root string) (*Graph, error) {
+	g := &Graph{
+		Path:  filepath.Join(root, ManifestFileName),
+		Nodes: make(map[string]*Node),
+	}
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if name == ".git" || name == "node_modules" || name == ".agentic" || name == "vendor" {
+			return filepath.SkipDir
+		}
+		metaPath := filepath.Join(path, MetaFileName)
+		if _, err := os.Stat(metaPath); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		node := &Node{
+			ID:      name,
+			Type:    NodeTypeLeaf,
+			Path:    rel,
+			Version: 1,
+		}
+		if err := loadNodeMeta(root, node); err != nil {
+			return err
+		}
+		if node.Meta != nil && node.Meta.ID != "" {
+			node.ID = node.Meta.ID
+		}
+		g.Nodes[node.ID] = node
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("discovering nodes: %w", err)
+	}
+
+	for _, node := range g.Nodes {
+		deps, err := inferDependencies(root, node, g.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		node.Dependencies = deps
+	}
+
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	g.linkDependents()
+	order, err := g.topologicalSortDiscovered()
+	if err != nil {
+		return nil, err
+	}
+	g.Order = order
+	return g, nil
+}
+
+// inferDependencies scans a node's Go sources for imports that resolve
+// to other discovered nodes.
+func inferDependencies(root string, node *Node, nodes map[string]*Node) ([]string, error) {
+	depSet := make(map[string]bool)
+	dir := filepath.Join(root, node.Path)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.Contains(line, "\"") {
+				continue
+			}
+			start := strings.Index(line, "\"")
+			end := strings.LastIndex(line, "\"")
+			if end <= start {
+				continue
+			}
+			importPath := line[start+1 : end]
+			last := importPath[strings.LastIndex(importPath, "/")+1:]
+			if last != node.ID {
+				if _, ok := nodes[last]; ok {
+					depSet[last] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inferring dependencies for %s: %w", node.ID, err)
+	}
+	deps := make([]string, 0, len(depSet))
+	for dep := range depSet {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps, nil
+}
+
+// topologicalSortDiscovered orders discovered nodes; identical to
+// TopologicalSort but kept separate so discovery can evolve its own
+// tie-breaking (e.g. directory order) without affecting loads.
+func (g *Graph) topologicalSortDiscovered() ([]string, error) {
+	return g.TopologicalSort()
+}
+
+// MergeDiscovered merges a freshly discovered graph into an existing
+// manifest graph. Discovered paths and dependency lists win; nodes
+// present in both keep their hand-maintained token cap, version, tags,
+// and type. New nodes are added, vanished nodes dropped.
+func MergeDiscovered(existing, discovered *Graph) (*Graph, error) {
+	merged := &Graph{
+		Path:  existing.Path,
+		Nodes: make(map[string]*Node, len(discovered.Nodes)),
+	}
+	for id, d := range discovered.Nodes {
+		node := &Node{
+			ID:           id,
+			Type:         d.Type,
+			Path:         d.Path,
+			Dependencies: d.Dependencies,
+			TokenCap:     d.TokenCap,
+			Version:      d.Version,
+			Tags:         d.Tags,
+			Meta:         d.Meta,
+		}
+		if old, ok := existing.Nodes[id]; ok {
+			node.Type = old.Type
+			if old.TokenCap != 0 {
+				node.TokenCap = old.TokenCap
+			}
+			node.Version = old.Version
+			node.Tags = old.Tags
+		}
+		merged.Nodes[id] = node
+	}
+
+	if err := merged.validate(); err != nil {
+		return nil, err
+	}
+	merged.linkDependents()
+	order, err := merged.TopologicalSort()
+	if err != nil {
+		return nil, err
+	}
+	merged.Order = order
+	return merged, nil
+}
+
+// sortedNodeIDs returns the map keys in sorted order.
+func sortedNodeIDs(nodes map[string]*Node) []string {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
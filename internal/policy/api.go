@@ -0,0 +1,262 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aid/agentic/internal/workspace"
+)
+
+// ExportedSymbols parses the Go files under a node directory and
+// returns each file's exported declarations (functions, methods,
+// types, consts, vars) rendered as signatures, keyed by path relative
+// to the node directory. Unlike the line-based contract hashing, this
+// is AST-based, so multi-line signatures and grouped declarations are
+// reported accurately.
+func ExportedSymbols(nodeDir string) (map[string][]string, error) {
+	symbols := make(map[string][]string)
+	err := filepath.WalkDir(nodeDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		if strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		sigs, err := fileExportedSymbols(path)
+		if err != nil {
+			return err
+		}
+		if len(sigs) == 0 {
+			return nil
+		}
+		rel, relErr := filepath.Rel(nodeDir, path)
+		if relErr != nil {
+			rel = path
+		}
+		symbols[rel] = sigs
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing exported symbols in %s: %w", nodeDir, err)
+	}
+	return symbols, nil
+}
+
+// fileExportedSymbols extracts the exported declarations of one Go
+// file in source order.
+func fileExportedSymbols(path string) ([]string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var sigs []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			// Methods on unexported receivers are not part of the
+			// public surface.
+			if d.Recv != nil && !exportedReceiver(d.Recv) {
+				continue
+			}
+			sigs = append(sigs, renderFuncSignature(fset, d))
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						sigs = append(sigs, "type "+s.Name.Name+typeKind(s.Type))
+					}
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.IsExported() {
+							sigs = append(sigs, d.Tok.String()+" "+name.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return sigs, nil
+}
+
+// exportedReceiver reports whether a method receiver names an exported
+// type.
+func exportedReceiver(recv *ast.FieldList) bool {
+	if len(recv.List) == 0 {
+		return false
+	}
+	t := recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if idx, ok := t.(*ast.IndexExpr); ok {
+		t = idx.X
+	}
+	ident, ok := t.(*ast.Ident)
+	return ok && ident.IsExported()
+}
+
+// renderFuncSignature prints a function or method declaration without
+// its body.
+func renderFuncSignature(fset *token.FileSet, d *ast.FuncDecl) string {
+	stripped := *d
+	stripped.Body = nil
+	stripped.Doc = nil
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, &stripped); err != nil {
+		return "func " + d.Name.Name
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// typeKind summarizes what kind of type a TypeSpec declares, for the
+// listing.
+func typeKind(expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.StructType:
+		return " struct"
+	case *ast.InterfaceType:
+		return " interface"
+	default:
+		return ""
+	}
+}
+
+// contractSignatures renders one file's exported declarations in the
+// canonical form used for contract hashing: full func/method
+// signatures, exported types reduced to their exported fields and
+// methods, and exported consts and vars. Whitespace is collapsed so
+// formatting churn does not change the hash. With src non-nil the
+// in-memory content is parsed instead of the file on disk.
+func contractSignatures(path string, src []byte) ([]string, error) {
+	fset := token.NewFileSet()
+	var parseSrc any
+	if src != nil {
+		parseSrc = src
+	}
+	file, err := parser.ParseFile(fset, path, parseSrc, 0)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	// FileExports trims the AST to exported declarations, including
+	// dropping unexported struct fields and interface methods.
+	if !ast.FileExports(file) {
+		return nil, nil
+	}
+
+	var sigs []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv != nil && !exportedReceiver(d.Recv) {
+				continue
+			}
+			d.Body = nil
+			d.Doc = nil
+		case *ast.GenDecl:
+			d.Doc = nil
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, decl); err != nil {
+			continue
+		}
+		sigs = append(sigs, strings.Join(strings.Fields(buf.String()), " "))
+	}
+	return sigs, nil
+}
+
+// nodeSignatures collects the sorted contract signatures of every Go
+// file under a node directory. Staged content overrides what is on
+// disk, so the signatures reflect the API as it would look after an
+// apply; staged deletions remove their file's contribution. Staged
+// keys are repo-relative, matching the paths WalkDir reports.
+func nodeSignatures(nodeDir string, staged map[string]string) ([]string, error) {
+	var all []string
+	seen := make(map[string]bool)
+	collect := func(path string, src []byte) error {
+		sigs, err := contractSignatures(path, src)
+		if err != nil {
+			return err
+		}
+		all = append(all, sigs...)
+		return nil
+	}
+
+	err := filepath.WalkDir(nodeDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		if strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		seen[path] = true
+		if content, ok := staged[path]; ok {
+			if content == workspace.DeletedFile {
+				return nil
+			}
+			return collect(path, []byte(content))
+		}
+		return collect(path, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("collecting signatures in %s: %w", nodeDir, err)
+	}
+
+	// Staged files that do not exist on disk yet still contribute.
+	for path, content := range staged {
+		if seen[path] || content == workspace.DeletedFile {
+			continue
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		if path != nodeDir && !strings.HasPrefix(path, nodeDir+string(filepath.Separator)) {
+			continue
+		}
+		if err := collect(path, []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(all)
+	return all, nil
+}
+
+// fileImports parses just the import block of in-memory Go source and
+// returns the imported paths.
+func fileImports(path, src string) ([]string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	imports := make([]string, 0, len(file.Imports))
+	for _, imp := range file.Imports {
+		imports = append(imports, strings.Trim(imp.Path.Value, `"`))
+	}
+	return imports, nil
+}
+
+// SortedSymbolFiles returns the file keys of an ExportedSymbols result
+// in stable order.
+func SortedSymbolFiles(symbols map[string][]string) []string {
+	files := make([]string, 0, len(symbols))
+	for file := range symbols {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
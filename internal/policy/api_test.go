@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/workspace"
+)
+
+// TestCheckBreakingChangesStagedOverlay stages a signature change
+// against a baseline taken from disk and expects an error violation.
+// Staged keys are repo-relative and must line up with the paths
+// WalkDir reports; with mismatched keys the check silently compares
+// disk against the baseline and the change sails through.
+func TestCheckBreakingChangesStagedOverlay(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("AGENTIC_HOME", filepath.Join(root, ".agentic"))
+	if err := os.MkdirAll(filepath.Join(root, ".agentic"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeDir := filepath.Join(root, "nodes", "demo")
+	if err := os.MkdirAll(filepath.Join(nodeDir, "SRC"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const original = "package demo\n\nfunc Greet(name string) string { return name }\n"
+	if err := os.WriteFile(filepath.Join(nodeDir, "SRC", "demo.go"), []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &graph.Node{ID: "demo", Path: nodeDir}
+
+	sigs, err := nodeSignatures(nodeDir, nil)
+	if err != nil {
+		t.Fatalf("nodeSignatures: %v", err)
+	}
+	if len(sigs) == 0 {
+		t.Fatal("expected baseline signatures, got none")
+	}
+	if err := SaveContractHashes(
+		map[string]string{node.ID: hashSignatures(sigs)},
+		map[string][]string{node.ID: sigs},
+	); err != nil {
+		t.Fatalf("SaveContractHashes: %v", err)
+	}
+
+	demoPath := filepath.Join(nodeDir, "SRC", "demo.go")
+
+	// Unchanged staged content is clean.
+	staged := map[string]string{demoPath: original}
+	if got := checkBreakingChanges(node, staged); len(got) != 0 {
+		t.Fatalf("unchanged content: got %d violations, want 0: %v", len(got), got)
+	}
+
+	// Changing an exported signature must be flagged as an error.
+	staged = map[string]string{
+		demoPath: "package demo\n\nfunc Greet(name string, loud bool) string { return name }\n",
+	}
+	got := checkBreakingChanges(node, staged)
+	if len(got) == 0 {
+		t.Fatal("staged signature change: got no violations, want an error")
+	}
+	if got[0].Policy != PolicyBreakingChange || got[0].Severity != SeverityError {
+		t.Fatalf("got %s/%s, want %s/%s", got[0].Policy, got[0].Severity, PolicyBreakingChange, SeverityError)
+	}
+
+	// Staged deletions remove every symbol in the file.
+	staged = map[string]string{demoPath: workspace.DeletedFile}
+	if got := checkBreakingChanges(node, staged); len(got) == 0 {
+		t.Fatal("staged deletion: got no violations, want an error")
+	}
+}
@@ -0,0 +1,935 @@
+// Package policy enforces token budgets, diff scopes, and contract
+// change detection for staged node changes.
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aid/agentic/internal/bundle"
+	"github.com/aid/agentic/internal/graph"
+	"github.com/aid/agentic/internal/token"
+	"github.com/aid/agentic/internal/workspace"
+)
+
+// Severity levels for violations.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Policy identifiers.
+const (
+	PolicyTokenBudget     = "token_budget"
+	PolicyDiffScope       = "diff_scope"
+	PolicyContractChange  = "contract_change"
+	PolicyBreakingChange  = "breaking_change"
+	PolicyForbiddenImport = "forbidden_import"
+	PolicyMaxFiles        = "max_files"
+	PolicyOutputFiles     = "output_files"
+	PolicyRequireHeader   = "require_header"
+)
+
+// contractHashesPath returns where the contract hash baseline is
+// stored. Honors AGENTIC_HOME via workspace.DataDir.
+func contractHashesPath() string {
+	return filepath.Join(workspace.DataDir(), "contracts.json")
+}
+
+// Violation is a single policy finding.
+type Violation struct {
+	Policy   string `json:"policy"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("[%s] %s: %s", v.Severity, v.Policy, v.Message)
+}
+
+// relativeStaged rekeys a repo-relative staged map onto the node
+// directory, for the checks whose patterns are node-relative. Paths
+// outside the node keep a ../-prefixed key, so scope checks still see
+// and reject them.
+func relativeStaged(node *graph.Node, staged map[string]string) map[string]string {
+	rel := make(map[string]string, len(staged))
+	for path, content := range staged {
+		if r, err := filepath.Rel(node.Path, path); err == nil {
+			rel[r] = content
+		} else {
+			rel[path] = content
+		}
+	}
+	return rel
+}
+
+// severityFor resolves the severity of a policy's violations: the
+// node's policies.severity map wins when it names the policy with a
+// valid level, otherwise the built-in default applies.
+func severityFor(node *graph.Node, policy, def string) string {
+	if node.Meta != nil {
+		if s, ok := node.Meta.Policies.Severity[policy]; ok && (s == SeverityError || s == SeverityWarning) {
+			return s
+		}
+	}
+	return def
+}
+
+// HasErrors reports whether any violation is error severity.
+func HasErrors(violations []Violation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate runs all policies against a node's staged changes and
+// returns the violations found. Staged keys are repo-relative; the
+// per-file checks match them against the node's policy patterns, which
+// are written relative to the node directory.
+func Evaluate(node *graph.Node, b *bundle.Bundle, staged map[string]string) []Violation {
+	var violations []Violation
+	rel := relativeStaged(node, staged)
+
+	violations = append(violations, checkTokenBudget(node, b, rel)...)
+	violations = append(violations, checkDiffScope(node, rel)...)
+	violations = append(violations, checkContractChanges(node, rel)...)
+	violations = append(violations, checkBreakingChanges(node, staged)...)
+	violations = append(violations, checkForbiddenImports(node, rel)...)
+	violations = append(violations, checkMaxFiles(node, b, rel)...)
+	violations = append(violations, checkOutputFiles(node, rel)...)
+	violations = append(violations, checkRequireHeader(node, rel)...)
+
+	return violations
+}
+
+// RequiredHeader resolves a node's policies.require_header setting to
+// the header text: the contents of the named file when one exists,
+// otherwise the literal string. Empty when unset.
+func RequiredHeader(node *graph.Node) string {
+	if node.Meta == nil || node.Meta.Policies.RequireHeader == "" {
+		return ""
+	}
+	header := node.Meta.Policies.RequireHeader
+	if data, err := os.ReadFile(header); err == nil {
+		return string(data)
+	}
+	return header
+}
+
+// headerExempt reports whether a path matches a header_ignore pattern,
+// exempting generated files from the header requirement.
+func headerExempt(node *graph.Node, path string) bool {
+	for _, pattern := range node.Meta.Policies.HeaderIgnore {
+		if MatchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequireHeader flags staged Go files that do not start with the
+// node's required license header.
+func checkRequireHeader(node *graph.Node, staged map[string]string) []Violation {
+	header := RequiredHeader(node)
+	if header == "" {
+		return nil
+	}
+	paths := make([]string, 0, len(staged))
+	for path := range staged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var violations []Violation
+	for _, path := range paths {
+		content := staged[path]
+		if !strings.HasSuffix(path, ".go") || content == workspace.DeletedFile || headerExempt(node, path) {
+			continue
+		}
+		if !strings.HasPrefix(content, strings.TrimRight(header, "\n")) {
+			violations = append(violations, Violation{
+				Policy:   PolicyRequireHeader,
+				Severity: severityFor(node, PolicyRequireHeader, SeverityWarning),
+				Message:  fmt.Sprintf("file %s is missing the required license header (apply --fix-headers to add it)", path),
+			})
+		}
+	}
+	return violations
+}
+
+// FixHeaders prepends the required header to staged Go files that lack
+// it, mutating the staged map in place. Returns the fixed paths. Keys
+// are repo-relative; header_ignore patterns match node-relative.
+func FixHeaders(node *graph.Node, staged map[string]string) []string {
+	header := RequiredHeader(node)
+	if header == "" {
+		return nil
+	}
+	if !strings.HasSuffix(header, "\n") {
+		header += "\n"
+	}
+	var fixed []string
+	for path, content := range staged {
+		rel := path
+		if r, err := filepath.Rel(node.Path, path); err == nil {
+			rel = r
+		}
+		if !strings.HasSuffix(path, ".go") || content == workspace.DeletedFile || headerExempt(node, rel) {
+			continue
+		}
+		if strings.HasPrefix(content, strings.TrimRight(header, "\n")) {
+			continue
+		}
+		staged[path] = header + content
+		fixed = append(fixed, path)
+	}
+	sort.Strings(fixed)
+	return fixed
+}
+
+// checkOutputFiles rejects staged files outside the node's exact
+// policies.output_files whitelist. Unlike allowed_paths there is no
+// glob matching and no new files: the layout is fixed, only content
+// may change.
+func checkOutputFiles(node *graph.Node, staged map[string]string) []Violation {
+	if node.Meta == nil || len(node.Meta.Policies.OutputFiles) == 0 {
+		return nil
+	}
+	whitelist := make(map[string]bool, len(node.Meta.Policies.OutputFiles))
+	for _, path := range node.Meta.Policies.OutputFiles {
+		whitelist[path] = true
+	}
+	paths := make([]string, 0, len(staged))
+	for path := range staged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var violations []Violation
+	for _, path := range paths {
+		if !whitelist[path] {
+			violations = append(violations, Violation{
+				Policy:   PolicyOutputFiles,
+				Severity: severityFor(node, PolicyOutputFiles, SeverityError),
+				Message:  fmt.Sprintf("file %s is not in the output_files whitelist for node %s", path, node.ID),
+			})
+		}
+	}
+	return violations
+}
+
+// checkMaxFiles flags nodes that would hold more files than their
+// policies.max_files cap after the staged changes, nudging a split
+// before the node sprawls. Severity defaults to warning and can be
+// raised to error with max_files_severity.
+func checkMaxFiles(node *graph.Node, b *bundle.Bundle, staged map[string]string) []Violation {
+	if node.Meta == nil || node.Meta.Policies.MaxFiles == 0 {
+		return nil
+	}
+	files := make(map[string]bool, len(b.Files))
+	for path := range b.Files {
+		files[path] = true
+	}
+	for path, content := range staged {
+		if content == workspace.DeletedFile {
+			delete(files, path)
+			continue
+		}
+		files[path] = true
+	}
+	if len(files) <= node.Meta.Policies.MaxFiles {
+		return nil
+	}
+	severity := SeverityWarning
+	if node.Meta.Policies.MaxFilesSeverity == SeverityError {
+		severity = SeverityError
+	}
+	return []Violation{{
+		Policy:   PolicyMaxFiles,
+		Severity: severity,
+		Message: fmt.Sprintf("node %s would hold %d files, max_files is %d; consider 'agentic split %s'",
+			node.ID, len(files), node.Meta.Policies.MaxFiles, node.ID),
+	}}
+}
+
+// checkForbiddenImports flags staged Go files importing paths the node
+// bans via policies.forbidden_imports. Patterns match exactly, by glob,
+// or as a "/..." subtree prefix.
+func checkForbiddenImports(node *graph.Node, staged map[string]string) []Violation {
+	if node.Meta == nil || len(node.Meta.Policies.ForbiddenImports) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(staged))
+	for path := range staged {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var violations []Violation
+	for _, path := range paths {
+		content := staged[path]
+		if !strings.HasSuffix(path, ".go") || content == workspace.DeletedFile {
+			continue
+		}
+		imports, err := fileImports(path, content)
+		if err != nil {
+			// Unparseable content is caught by extraction-time syntax
+			// validation.
+			continue
+		}
+		for _, imp := range imports {
+			for _, pattern := range node.Meta.Policies.ForbiddenImports {
+				if importMatches(pattern, imp) {
+					violations = append(violations, Violation{
+						Policy:   PolicyForbiddenImport,
+						Severity: severityFor(node, PolicyForbiddenImport, SeverityError),
+						Message:  fmt.Sprintf("file %s imports %q, forbidden for node %s (rule %s)", path, imp, node.ID, pattern),
+					})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// importMatches reports whether an import path falls under a
+// forbidden-import pattern.
+func importMatches(pattern, imp string) bool {
+	if strings.HasSuffix(pattern, "/...") {
+		root := strings.TrimSuffix(pattern, "/...")
+		return imp == root || strings.HasPrefix(imp, root+"/")
+	}
+	if matched, _ := filepath.Match(pattern, imp); matched {
+		return true
+	}
+	return pattern == imp
+}
+
+// checkBreakingChanges diffs the node's exported API, as it would look
+// after the staged changes, against the contracts.json baseline.
+// Removed or re-signatured symbols are errors, since dependents may
+// break; additions are warnings. Nodes without a stored baseline are
+// skipped.
+func checkBreakingChanges(node *graph.Node, staged map[string]string) []Violation {
+	store, err := loadContractStore()
+	if err != nil || len(store.Signatures[node.ID]) == 0 {
+		return nil
+	}
+	current, err := nodeSignatures(node.Path, staged)
+	if err != nil {
+		// Unparseable staged content is reported by extraction-time
+		// syntax validation; do not double up here.
+		return nil
+	}
+
+	baseline := make(map[string]bool, len(store.Signatures[node.ID]))
+	for _, sig := range store.Signatures[node.ID] {
+		baseline[sig] = true
+	}
+	now := make(map[string]bool, len(current))
+	for _, sig := range current {
+		now[sig] = true
+	}
+
+	var violations []Violation
+	for _, sig := range store.Signatures[node.ID] {
+		if !now[sig] {
+			violations = append(violations, Violation{
+				Policy:   PolicyBreakingChange,
+				Severity: severityFor(node, PolicyBreakingChange, SeverityError),
+				Message:  fmt.Sprintf("exported symbol removed or signature changed in %s: %s", node.ID, sig),
+			})
+		}
+	}
+	for _, sig := range current {
+		if !baseline[sig] {
+			violations = append(violations, Violation{
+				Policy:   PolicyBreakingChange,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("exported symbol added in %s: %s", node.ID, sig),
+			})
+		}
+	}
+	return violations
+}
+
+// checkTokenBudget flags nodes whose content would exceed their token
+// cap after the staged changes are applied. With budget_scope: source
+// the cap counts only the node's own files, not dependency contracts;
+// budget_count_ext restricts which extensions count at all, so large
+// data fixtures do not trigger spurious budget failures.
+func checkTokenBudget(node *graph.Node, b *bundle.Bundle, staged map[string]string) []Violation {
+	if node.TokenCap == 0 {
+		return nil
+	}
+	var countExt []string
+	if node.Meta != nil {
+		countExt = node.Meta.Budgets.CountExt
+	}
+	merged := make(map[string]string, len(b.Files))
+	for path, content := range b.Files {
+		if countsTowardBudget(path, countExt) {
+			merged[path] = content
+		}
+	}
+	for path, content := range staged {
+		if content == workspace.DeletedFile {
+			delete(merged, path)
+			continue
+		}
+		if countsTowardBudget(path, countExt) {
+			merged[path] = content
+		}
+	}
+	estimate := token.EstimateMap(merged)
+	scope := ""
+	if node.Meta != nil {
+		scope = node.Meta.Budgets.Scope
+	}
+	if scope != graph.BudgetScopeSource {
+		for path, content := range b.Contracts {
+			if countsTowardBudget(path, countExt) {
+				estimate += token.EstimateString(content)
+			}
+		}
+	}
+	if estimate > node.TokenCap {
+		msg := fmt.Sprintf("node %s would be ~%d tokens, cap is %d", node.ID, estimate, node.TokenCap)
+		if scope == graph.BudgetScopeSource {
+			msg += " (source scope)"
+		}
+		return []Violation{{
+			Policy:   PolicyTokenBudget,
+			Severity: severityFor(node, PolicyTokenBudget, SeverityError),
+			Message:  msg,
+		}}
+	}
+	return nil
+}
+
+// countsTowardBudget reports whether a path's extension is in the
+// budget_count_ext list; an empty list counts everything.
+func countsTowardBudget(path string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	ext := filepath.Ext(path)
+	for _, e := range exts {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDiffScope flags staged files that fall outside the node's
+// allowed paths. With allow_new_dirs, new files under the directory
+// root of an allowed pattern pass even when no pattern matches them
+// exactly; new files that would create an entirely new directory tree
+// are still flagged, blocking structural sprawl.
+func checkDiffScope(node *graph.Node, staged map[string]string) []Violation {
+	if node.Meta == nil || len(node.Meta.Policies.AllowedPaths) == 0 {
+		return nil
+	}
+	var violations []Violation
+	for path := range staged {
+		allowed := false
+		for _, pattern := range node.Meta.Policies.AllowedPaths {
+			if MatchPath(pattern, path) {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			continue
+		}
+		if node.Meta.Policies.AllowNewDirs {
+			if _, err := os.Stat(path); err != nil { // new file
+				if underAllowedRoot(path, node.Meta.Policies.AllowedPaths) {
+					continue
+				}
+				violations = append(violations, Violation{
+					Policy:   PolicyDiffScope,
+					Severity: severityFor(node, PolicyDiffScope, SeverityError),
+					Message:  fmt.Sprintf("new file %s would create a directory outside allowed roots", path),
+				})
+				continue
+			}
+		}
+		violations = append(violations, Violation{
+			Policy:   PolicyDiffScope,
+			Severity: severityFor(node, PolicyDiffScope, SeverityError),
+			Message:  fmt.Sprintf("file %s is outside allowed paths %v", path, node.Meta.Policies.AllowedPaths),
+		})
+	}
+	return violations
+}
+
+// InScope reports whether a node may touch path under its
+// allowed_paths policy. Nodes without allowed_paths are unrestricted;
+// with allow_new_dirs a new file under the directory root of an
+// allowed pattern is in scope, matching checkDiffScope.
+func InScope(node *graph.Node, path string) bool {
+	if node.Meta == nil || len(node.Meta.Policies.AllowedPaths) == 0 {
+		return true
+	}
+	for _, pattern := range node.Meta.Policies.AllowedPaths {
+		if MatchPath(pattern, path) {
+			return true
+		}
+	}
+	if node.Meta.Policies.AllowNewDirs {
+		if _, err := os.Stat(path); err != nil { // new file
+			return underAllowedRoot(path, node.Meta.Policies.AllowedPaths)
+		}
+	}
+	return false
+}
+
+// underAllowedRoot reports whether a path sits under the literal
+// directory root of any allowed pattern.
+func underAllowedRoot(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		root := patternRoot(pattern)
+		if root == "" {
+			continue
+		}
+		if strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// patternRoot extracts the literal directory prefix of a pattern:
+// "src/" -> "src", "src/*.go" -> "src", "README.md" -> "".
+func patternRoot(pattern string) string {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.TrimSuffix(pattern, "/")
+	}
+	if idx := strings.IndexAny(pattern, "*?["); idx >= 0 {
+		pattern = pattern[:idx]
+	}
+	if j := strings.LastIndexByte(pattern, '/'); j >= 0 {
+		return pattern[:j]
+	}
+	return ""
+}
+
+// MatchPath reports whether a path matches an allowed-path pattern.
+// Patterns ending in "/" match the whole subtree; otherwise glob
+// matching is applied, with "**/" prefixes matching any directory.
+func MatchPath(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern) || path == strings.TrimSuffix(pattern, "/")
+	}
+	if matched, _ := filepath.Match(pattern, path); matched {
+		return true
+	}
+	if strings.HasPrefix(pattern, "**/") {
+		if matched, _ := filepath.Match(strings.TrimPrefix(pattern, "**/"), filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContractChanges warns when staged changes touch files in the
+// node's CONTRACTS directory, since dependents consume those.
+func checkContractChanges(node *graph.Node, staged map[string]string) []Violation {
+	var violations []Violation
+	for path := range staged {
+		if strings.HasPrefix(path, bundle.ContractsDirName+"/") {
+			violations = append(violations, Violation{
+				Policy:   PolicyContractChange,
+				Severity: severityFor(node, PolicyContractChange, SeverityWarning),
+				Message:  fmt.Sprintf("contract file %s modified; dependents of %s may be affected", path, node.ID),
+			})
+		}
+	}
+	return violations
+}
+
+// HashContracts computes a hash of a node's exported API surface from
+// the Go files under its path. Signatures come from the AST (see
+// contractSignatures), so multi-line declarations, interface method
+// sets, struct fields, and generics are all captured, and symbols
+// mentioned in comments or strings are not.
+func HashContracts(nodeDir string) (string, error) {
+	sigs, err := nodeSignatures(nodeDir, nil)
+	if err != nil {
+		return "", fmt.Errorf("hashing contracts in %s: %w", nodeDir, err)
+	}
+	return hashSignatures(sigs), nil
+}
+
+// hashSignatures reduces a sorted signature list to its hash.
+func hashSignatures(sigs []string) string {
+	h := sha256.New()
+	for _, sig := range sigs {
+		fmt.Fprintf(h, "%s\n", sig)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contractStore is the on-disk format of contracts.json: the hash
+// baseline, the per-node signature lists it was computed from, and the
+// time it was taken, used for incremental updates.
+type contractStore struct {
+	UpdatedAt  time.Time           `json:"updated_at"`
+	Hashes     map[string]string   `json:"hashes"`
+	Signatures map[string][]string `json:"signatures,omitempty"`
+}
+
+// SaveContractHashes writes the node->hash baseline and the signature
+// lists behind it to disk, stamped with the current time.
+func SaveContractHashes(hashes map[string]string, signatures map[string][]string) error {
+	if err := os.MkdirAll(workspace.DataDir(), 0755); err != nil {
+		return err
+	}
+	store := contractStore{UpdatedAt: time.Now(), Hashes: hashes, Signatures: signatures}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(contractHashesPath(), data, 0644)
+}
+
+// loadContractStore reads contracts.json. A missing file returns an
+// empty store; the legacy plain-map format (no timestamp) is accepted.
+func loadContractStore() (contractStore, error) {
+	store := contractStore{Hashes: map[string]string{}}
+	data, err := os.ReadFile(contractHashesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil || store.Hashes == nil {
+		// Legacy format: a bare node->hash map with no timestamp.
+		legacy := make(map[string]string)
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return store, fmt.Errorf("parsing %s: %w", contractHashesPath(), err)
+		}
+		store = contractStore{Hashes: legacy}
+	}
+	return store, nil
+}
+
+// LoadContractHashes reads the stored node->hash baseline. A missing
+// file returns an empty map.
+func LoadContractHashes() (map[string]string, error) {
+	store, err := loadContractStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Hashes, nil
+}
+
+// nodeChangedSince reports whether any Go file under dir was modified
+// after t.
+func nodeChangedSince(dir string, t time.Time) (bool, error) {
+	changed := false
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || changed || d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(t) {
+			changed = true
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// UpdateContractHashes recomputes the contract hash for each node and
+// saves the new baseline. Unless force is set, nodes whose sources have
+// not been modified since the stored baseline keep their stored hash,
+// making frequent updates (e.g. in a pre-commit hook) cheap. Returns
+// the IDs of nodes that were rehashed.
+func UpdateContractHashes(root string, nodes map[string]*graph.Node, force bool) ([]string, error) {
+	store, err := loadContractStore()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(nodes))
+	signatures := make(map[string][]string, len(nodes))
+	var rehashed []string
+	for id, node := range nodes {
+		dir := filepath.Join(root, node.Path)
+		if !force {
+			if stored, ok := store.Hashes[id]; ok && !store.UpdatedAt.IsZero() {
+				changed, err := nodeChangedSince(dir, store.UpdatedAt)
+				if err != nil {
+					return nil, fmt.Errorf("checking %s for changes: %w", id, err)
+				}
+				if !changed {
+					hashes[id] = stored
+					signatures[id] = store.Signatures[id]
+					continue
+				}
+			}
+		}
+		sigs, err := nodeSignatures(dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		hashes[id] = hashSignatures(sigs)
+		signatures[id] = sigs
+		rehashed = append(rehashed, id)
+	}
+	sort.Strings(rehashed)
+
+	if err := SaveContractHashes(hashes, signatures); err != nil {
+		return nil, err
+	}
+	return rehashed, nil
+}
+
+// UpdateContractHash recomputes and stores the baseline for a single
+// node, leaving other nodes' entries untouched.
+func UpdateContractHash(root string, node *graph.Node) error {
+	store, err := loadContractStore()
+	if err != nil {
+		return err
+	}
+	sigs, err := nodeSignatures(filepath.Join(root, node.Path), nil)
+	if err != nil {
+		return err
+	}
+	if store.Hashes == nil {
+		store.Hashes = make(map[string]string)
+	}
+	if store.Signatures == nil {
+		store.Signatures = make(map[string][]string)
+	}
+	store.Hashes[node.ID] = hashSignatures(sigs)
+	store.Signatures[node.ID] = sigs
+	return SaveContractHashes(store.Hashes, store.Signatures)
+}
+
+// HasContractChanged reports whether a node's exported API differs from
+// the stored baseline. Nodes without a baseline report false.
+func HasContractChanged(nodeID, nodeDir string) (bool, error) {
+	stored, err := LoadContractHashes()
+	if err != nil {
+		return false, err
+	}
+	baseline, ok := stored[nodeID]
+	if !ok {
+		return false, nil
+	}
+	current, err := HashContracts(nodeDir)
+	if err != nil {
+		return false, err
+	}
+	return current != baseline, nil
+}
+
+// ShouldSplit reports whether a node's estimated size warrants
+// splitting into child nodes.
+func ShouldSplit(node *graph.Node, estimate int) bool {
+	return node.TokenCap > 0 && estimate > node.TokenCap
+}
+
+// SuggestSplit proposes child-node groupings for an over-budget node
+// based on its directory structure.
+func SuggestSplit(node *graph.Node, b *bundle.Bundle) []string {
+	var suggestions []string
+	for _, group := range ProposeSplit(node, b) {
+		suggestions = append(suggestions,
+			fmt.Sprintf("split %s into child node (~%d tokens)", group.Dir, group.Tokens))
+	}
+	return suggestions
+}
+
+// SplitGroup is one proposed child node in a split: the directory it
+// covers, the files assigned to it, and their token estimate.
+type SplitGroup struct {
+	Dir    string
+	Files  []string
+	Tokens int
+}
+
+// ProposeSplit groups a node's files by directory — the heuristic
+// SuggestSplit summarizes — and returns the concrete file-to-child
+// assignment, largest group first.
+func ProposeSplit(node *graph.Node, b *bundle.Bundle) []SplitGroup {
+	byDir := make(map[string]*SplitGroup)
+	for path, content := range b.Files {
+		dir := filepath.Dir(path)
+		if dir == "." {
+			dir = "(root)"
+		}
+		group, ok := byDir[dir]
+		if !ok {
+			group = &SplitGroup{Dir: dir}
+			byDir[dir] = group
+		}
+		group.Files = append(group.Files, path)
+		group.Tokens += token.EstimateString(content)
+	}
+
+	groups := make([]SplitGroup, 0, len(byDir))
+	for _, group := range byDir {
+		sort.Strings(group.Files)
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Tokens != groups[j].Tokens {
+			return groups[i].Tokens > groups[j].Tokens
+		}
+		return groups[i].Dir < groups[j].Dir
+	})
+	return groups
+}
+
+// checkTemplateData is the data available to templated check commands.
+type checkTemplateData struct {
+	// ChangedFiles is the space-joined list of changed file paths.
+	ChangedFiles string
+	// ChangedPackages is the space-joined list of ./dir packages
+	// containing changed Go files.
+	ChangedPackages string
+}
+
+// renderCheck renders a check command as a Go template with the
+// changed-file data, enabling incremental checks like
+// "gofmt -l {{.ChangedFiles}}" or "go test {{.ChangedPackages}}".
+func renderCheck(check string, changed []string) (string, error) {
+	if !strings.Contains(check, "{{") {
+		return check, nil
+	}
+	pkgSet := make(map[string]bool)
+	for _, path := range changed {
+		if strings.HasSuffix(path, ".go") {
+			dir := filepath.Dir(path)
+			if dir == "." {
+				pkgSet["./."] = true
+			} else {
+				pkgSet["./"+dir] = true
+			}
+		}
+	}
+	pkgs := make([]string, 0, len(pkgSet))
+	for pkg := range pkgSet {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+
+	tmpl, err := template.New("check").Parse(check)
+	if err != nil {
+		return "", fmt.Errorf("parsing check template %q: %w", check, err)
+	}
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, checkTemplateData{
+		ChangedFiles:    strings.Join(changed, " "),
+		ChangedPackages: strings.Join(pkgs, " "),
+	})
+	if err != nil {
+		return "", fmt.Errorf("rendering check template %q: %w", check, err)
+	}
+	return sb.String(), nil
+}
+
+// DefaultCheckTimeout bounds each check command when the node does not
+// set policies.check_timeout.
+const DefaultCheckTimeout = 5 * time.Minute
+
+// defaultCheckTimeout is the fallback actually applied; the project
+// config file may override the built-in default.
+var defaultCheckTimeout = DefaultCheckTimeout
+
+// SetDefaultCheckTimeout overrides the fallback check timeout for
+// nodes that set no check_timeout of their own.
+func SetDefaultCheckTimeout(d time.Duration) {
+	if d > 0 {
+		defaultCheckTimeout = d
+	}
+}
+
+// checkTimeout resolves the per-check timeout for a node.
+func checkTimeout(node *graph.Node) time.Duration {
+	if node.Meta != nil && node.Meta.Policies.CheckTimeout != "" {
+		if d, err := time.ParseDuration(node.Meta.Policies.CheckTimeout); err == nil && d > 0 {
+			return d
+		}
+		fmt.Fprintf(os.Stderr, "warning: invalid check_timeout %q for node %s; using default\n",
+			node.Meta.Policies.CheckTimeout, node.ID)
+	}
+	return defaultCheckTimeout
+}
+
+// RunChecks runs the node's configured check commands. Every check
+// runs even if an earlier one fails, and the failures are aggregated
+// into one error. Each command is killed after the node's check
+// timeout. With verbose, output streams live instead of being captured.
+// Check strings are rendered as Go templates with the changed files
+// available; templated checks are skipped when no changes are
+// supplied, since they have nothing to operate on.
+func RunChecks(node *graph.Node, changed []string, verbose bool) error {
+	if node.Meta == nil {
+		return nil
+	}
+	timeout := checkTimeout(node)
+	var failures []string
+	for _, check := range node.Meta.Policies.Checks {
+		if strings.Contains(check, "{{") && len(changed) == 0 {
+			continue
+		}
+		rendered, err := renderCheck(check, changed)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		cmd := exec.CommandContext(ctx, "sh", "-c", rendered)
+		var output []byte
+		if verbose {
+			fmt.Fprintf(os.Stderr, "check: %s\n", rendered)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			err = cmd.Run()
+		} else {
+			output, err = cmd.CombinedOutput()
+		}
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		cancel()
+		if timedOut {
+			failures = append(failures, fmt.Sprintf("check %q timed out after %s", rendered, timeout))
+			continue
+		}
+		if err != nil {
+			msg := fmt.Sprintf("check %q failed: %v", rendered, err)
+			if !verbose && len(output) > 0 {
+				msg += "\n" + strings.TrimRight(string(output), "\n")
+			}
+			failures = append(failures, msg)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d check(s) failed:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	return nil
+}
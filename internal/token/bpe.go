@@ -0,0 +1,104 @@
+package token
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Estimator names selectable via the config file.
+const (
+	EstimatorHeuristic = "heuristic"
+	EstimatorBPE       = "bpe"
+)
+
+// estimator is the active estimation algorithm; the flat
+// chars-per-token heuristic unless the config selects bpe.
+var estimator = EstimatorHeuristic
+
+// SetEstimator selects the estimation algorithm by name.
+func SetEstimator(name string) error {
+	switch name {
+	case EstimatorHeuristic, EstimatorBPE:
+		estimator = name
+		return nil
+	default:
+		return fmt.Errorf("unknown estimator %q (want %s or %s)", name, EstimatorHeuristic, EstimatorBPE)
+	}
+}
+
+// estimateBPE approximates how a cl100k/o200k-style BPE tokenizer
+// segments code, without shipping the multi-megabyte vocabulary:
+// identifiers cost roughly one token per subword, digit runs compress
+// to about three digits per token, symbol runs merge in pairs, and
+// non-ASCII text costs about a token per rune. Like the heuristic it
+// rounds up — overestimating wastes a little budget, underestimating
+// truncates context at the model.
+func estimateBPE(s string) int {
+	tokens := 0
+	runes := []rune(s)
+	i := 0
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			tokens++
+			i++
+		case r == ' ' || r == '\t':
+			// Indentation and single spaces merge into neighboring
+			// tokens; long runs still cost a little.
+			j := i
+			for j < len(runes) && (runes[j] == ' ' || runes[j] == '\t') {
+				j++
+			}
+			tokens += (j - i) / 8
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			// Identifiers split into subwords at case changes and
+			// underscores; each subword is roughly one token.
+			j := i
+			sub := 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				if j > i {
+					prev, cur := runes[j-1], runes[j]
+					if cur == '_' || (unicode.IsUpper(cur) && unicode.IsLower(prev)) {
+						sub++
+					}
+				}
+				if runes[j] > unicode.MaxASCII {
+					sub++
+				}
+				j++
+			}
+			// Long subwords fall apart into multiple tokens.
+			if length := j - i; length > 8*sub {
+				sub += (length - 8*sub) / 8
+			}
+			tokens += sub
+			i = j
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			tokens += (j-i+2)/3 + 0
+			i = j
+		case r > unicode.MaxASCII:
+			tokens++
+			i++
+		default:
+			// Symbol runs: common pairs like "()" or "):" merge.
+			j := i
+			for j < len(runes) && runes[j] <= unicode.MaxASCII &&
+				!unicode.IsLetter(runes[j]) && !unicode.IsDigit(runes[j]) &&
+				runes[j] != ' ' && runes[j] != '\t' && runes[j] != '\n' {
+				j++
+			}
+			tokens += (j - i + 1) / 2
+			i = j
+		}
+	}
+	if tokens == 0 {
+		tokens = 1
+	}
+	return tokens
+}
@@ -0,0 +1,172 @@
+// Package token provides token estimation and budget management for
+// sizing bundles against model context windows.
+//
+// Estimates are deliberately conservative (upper bound): overestimating
+// wastes a little budget, underestimating truncates context at the model.
+package token
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// charsPerToken is the heuristic divisor for estimation. Code averages
+// roughly 4 characters per token across the models we target.
+const charsPerToken = 4
+
+// Budget describes the context window of a model and how much of it we
+// reserve for the model's own output.
+type Budget struct {
+	Model          string
+	MaxContext     int
+	ReservedOutput int
+}
+
+// Available returns the number of tokens usable for the prompt.
+func (b Budget) Available() int {
+	return b.MaxContext - b.ReservedOutput
+}
+
+// DefaultBudgets maps model identifiers to their context budgets. The
+// config file's budgets: section can add or override entries via
+// SetBudget.
+var DefaultBudgets = map[string]Budget{
+	"claude-3-5-sonnet":  {Model: "claude-3-5-sonnet", MaxContext: 200000, ReservedOutput: 8192},
+	"claude-3-opus":      {Model: "claude-3-opus", MaxContext: 200000, ReservedOutput: 4096},
+	"claude-3-haiku":     {Model: "claude-3-haiku", MaxContext: 200000, ReservedOutput: 4096},
+	"claude-sonnet-4":    {Model: "claude-sonnet-4", MaxContext: 200000, ReservedOutput: 16384},
+	"claude-sonnet-4-1m": {Model: "claude-sonnet-4-1m", MaxContext: 1000000, ReservedOutput: 16384},
+	"claude-opus-4":      {Model: "claude-opus-4", MaxContext: 200000, ReservedOutput: 16384},
+	"gpt-4o":             {Model: "gpt-4o", MaxContext: 128000, ReservedOutput: 16384},
+	"gpt-4o-mini":        {Model: "gpt-4o-mini", MaxContext: 128000, ReservedOutput: 16384},
+	"gemini-1.5-pro":     {Model: "gemini-1.5-pro", MaxContext: 1048576, ReservedOutput: 8192},
+	"gemini-1.5-flash":   {Model: "gemini-1.5-flash", MaxContext: 1048576, ReservedOutput: 8192},
+	"default":            {Model: "default", MaxContext: 200000, ReservedOutput: 8192},
+}
+
+// SetBudget adds or overrides a model's budget. A zero reservedOutput
+// keeps any existing reservation for the model.
+func SetBudget(model string, maxContext, reservedOutput int) {
+	b := DefaultBudgets[model]
+	b.Model = model
+	if maxContext > 0 {
+		b.MaxContext = maxContext
+	}
+	if reservedOutput > 0 {
+		b.ReservedOutput = reservedOutput
+	}
+	DefaultBudgets[model] = b
+}
+
+// GetBudget returns the budget for a model, falling back to "default"
+// for unknown models.
+func GetBudget(model string) Budget {
+	if b, ok := DefaultBudgets[model]; ok {
+		return b
+	}
+	return DefaultBudgets["default"]
+}
+
+// EstimateString estimates the token count of a string using the
+// configured estimator.
+func EstimateString(s string) int {
+	if s == "" {
+		return 0
+	}
+	if estimator == EstimatorBPE {
+		return estimateBPE(s)
+	}
+	return len(s)/charsPerToken + 1
+}
+
+// langRatios maps file extensions to chars-per-token ratios. Prose and
+// data formats tokenize denser than code, so a flat ratio misjudges
+// them; unknown extensions fall back to charsPerToken.
+var langRatios = map[string]float64{
+	".go":   4.0,
+	".py":   4.0,
+	".js":   3.8,
+	".ts":   3.8,
+	".md":   3.5,
+	".txt":  3.5,
+	".yaml": 3.0,
+	".yml":  3.0,
+	".json": 3.0,
+	".html": 3.2,
+	".sh":   3.5,
+}
+
+// SetRatio overrides the chars-per-token ratio for a file extension
+// (leading dot optional).
+func SetRatio(ext string, charsPerToken float64) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	if charsPerToken > 0 {
+		langRatios[ext] = charsPerToken
+	}
+}
+
+// EstimateFile estimates the token count of one file, picking a
+// chars-per-token ratio from the file extension. The BPE estimator is
+// already symbol-aware and ignores the ratio table.
+func EstimateFile(path, content string) int {
+	if content == "" {
+		return 0
+	}
+	if estimator == EstimatorBPE {
+		return estimateBPE(content)
+	}
+	ratio, ok := langRatios[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		ratio = charsPerToken
+	}
+	return int(float64(len(content))/ratio) + 1
+}
+
+// EstimateMap estimates the total token count of a path->content map,
+// including a small per-entry overhead for file delimiters. Each file
+// is estimated with its extension's ratio.
+func EstimateMap(files map[string]string) int {
+	total := 0
+	for path, content := range files {
+		total += EstimateString(path) + EstimateFile(path, content) + 8
+	}
+	return total
+}
+
+// Calibration is the result of measuring a corpus against an actual
+// token count reported by a model or tokenizer.
+type Calibration struct {
+	// Chars is the total character count of the corpus.
+	Chars int
+	// EstimatedTokens is what the current heuristic predicts.
+	EstimatedTokens int
+	// ActualTokens is the measured token count; zero when unknown.
+	ActualTokens int
+	// CharsPerToken is the measured ratio, set when ActualTokens > 0.
+	CharsPerToken float64
+	// SuggestedDivisor is CharsPerToken rounded to the nearest integer,
+	// suitable as a replacement for the heuristic divisor.
+	SuggestedDivisor int
+}
+
+// Calibrate measures a file corpus and, given the actual token count a
+// model reported for it, derives the real chars-per-token ratio so the
+// heuristic divisor can be tuned for a particular language mix.
+func Calibrate(files map[string]string, actualTokens int) Calibration {
+	chars := 0
+	for _, content := range files {
+		chars += len(content)
+	}
+	c := Calibration{
+		Chars:           chars,
+		EstimatedTokens: EstimateMap(files),
+		ActualTokens:    actualTokens,
+	}
+	if actualTokens > 0 {
+		c.CharsPerToken = float64(chars) / float64(actualTokens)
+		c.SuggestedDivisor = int(c.CharsPerToken + 0.5)
+	}
+	return c
+}
@@ -0,0 +1,117 @@
+package token
+
+import "testing"
+
+// TestEstimateBPE pins the approximate-BPE estimates for representative
+// inputs. The exact numbers are not sacred, but they feed ShouldSplit
+// and token_budget decisions, so any drift should be a deliberate
+// change here rather than an accident in the segmentation arithmetic.
+func TestEstimateBPE(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{"code line", "func main() {}\n", 5},
+		{"camel case subwords", "parseHTTPResponse", 2},
+		{"snake case subwords", "parse_http_response", 3},
+		{"digit run", "1234567890", 4},
+		{"short digits", "42", 1},
+		{"accented word", "héllo", 2},
+		{"cjk text", "日本語のテキスト", 9},
+		{"symbol-heavy code", "x := map[string]int{\"a\": 1}\n", 13},
+		{"prose", "the quick brown fox jumps over the lazy dog", 9},
+		{"indented line", "        deeply indented line\n", 5},
+		{"long identifier fallback", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", 4},
+		{"empty input floor", "", 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := estimateBPE(tc.in); got != tc.want {
+				t.Errorf("estimateBPE(%q) = %d, want %d", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestEstimateFileRatios checks the extension ratio table: denser
+// formats cost more tokens for the same byte count, unknown extensions
+// fall back to the flat divisor, and SetRatio overrides take effect.
+func TestEstimateFileRatios(t *testing.T) {
+	content := make([]byte, 120)
+	for i := range content {
+		content[i] = 'a'
+	}
+	s := string(content)
+
+	goTokens := EstimateFile("main.go", s)       // ratio 4.0
+	yamlTokens := EstimateFile("config.yaml", s) // ratio 3.0
+	unknownTokens := EstimateFile("data.bin", s) // fallback 4
+	if goTokens != 31 {
+		t.Errorf("EstimateFile(.go) = %d, want 31", goTokens)
+	}
+	if yamlTokens != 41 {
+		t.Errorf("EstimateFile(.yaml) = %d, want 41", yamlTokens)
+	}
+	if unknownTokens != goTokens {
+		t.Errorf("unknown extension = %d, want flat-divisor %d", unknownTokens, goTokens)
+	}
+	if yamlTokens <= goTokens {
+		t.Errorf("yaml (%d) should cost more than go (%d) for the same bytes", yamlTokens, goTokens)
+	}
+
+	SetRatio("bin", 2.0)
+	defer delete(langRatios, ".bin")
+	if got := EstimateFile("data.bin", s); got != 61 {
+		t.Errorf("EstimateFile(.bin) after SetRatio = %d, want 61", got)
+	}
+
+	if got := EstimateFile("main.go", ""); got != 0 {
+		t.Errorf("empty file = %d, want 0", got)
+	}
+}
+
+// TestSetEstimator checks estimator selection switches EstimateString
+// between the flat heuristic and the BPE path, and rejects unknown
+// names.
+func TestSetEstimator(t *testing.T) {
+	defer func() { estimator = EstimatorHeuristic }()
+
+	const s = "parseHTTPResponse"
+	if got := EstimateString(s); got != len(s)/charsPerToken+1 {
+		t.Errorf("heuristic estimate = %d, want %d", got, len(s)/charsPerToken+1)
+	}
+	if err := SetEstimator(EstimatorBPE); err != nil {
+		t.Fatalf("SetEstimator(bpe): %v", err)
+	}
+	if got := EstimateString(s); got != 2 {
+		t.Errorf("bpe estimate = %d, want 2", got)
+	}
+	if err := SetEstimator("guesswork"); err == nil {
+		t.Error("expected error for unknown estimator name")
+	}
+}
+
+// TestCalibrate checks the measured ratio and suggested divisor derive
+// from the corpus size and the reported actual token count.
+func TestCalibrate(t *testing.T) {
+	files := map[string]string{"a.go": "package a\n" + string(make([]byte, 390))}
+	c := Calibrate(files, 100)
+	if c.Chars != 400 {
+		t.Errorf("Chars = %d, want 400", c.Chars)
+	}
+	if c.CharsPerToken != 4.0 {
+		t.Errorf("CharsPerToken = %v, want 4.0", c.CharsPerToken)
+	}
+	if c.SuggestedDivisor != 4 {
+		t.Errorf("SuggestedDivisor = %d, want 4", c.SuggestedDivisor)
+	}
+	if c.EstimatedTokens == 0 {
+		t.Error("EstimatedTokens should be non-zero for a non-empty corpus")
+	}
+
+	c = Calibrate(files, 0)
+	if c.ActualTokens != 0 || c.CharsPerToken != 0 || c.SuggestedDivisor != 0 {
+		t.Errorf("zero actual tokens should leave ratio fields unset, got %+v", c)
+	}
+}
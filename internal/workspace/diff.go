@@ -0,0 +1,165 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each hunk.
+const diffContext = 3
+
+// diffOp is one line of a computed diff: kind is ' ', '-', or '+'.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes a line-based diff between two line slices using an
+// LCS table. The common prefix and suffix are stripped first so the
+// table only covers the changed region.
+func diffLines(oldLines, newLines []string) []diffOp {
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(oldLines)+len(b))
+	for _, line := range oldLines[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// UnifiedDiff renders a unified diff between two file contents, with
+// @@ hunk headers and diffContext lines of context. Identical contents
+// render as the empty string.
+func UnifiedDiff(oldContent, newContent string) string {
+	if oldContent == newContent {
+		return ""
+	}
+	oldLines := strings.Split(strings.TrimSuffix(oldContent, "\n"), "\n")
+	newLines := strings.Split(strings.TrimSuffix(newContent, "\n"), "\n")
+	ops := diffLines(oldLines, newLines)
+
+	var changed []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	// Merge changes whose context windows touch into single hunks.
+	type span struct{ start, end int }
+	var hunks []span
+	cur := span{changed[0], changed[0] + 1}
+	for _, idx := range changed[1:] {
+		if idx-cur.end <= 2*diffContext {
+			cur.end = idx + 1
+		} else {
+			hunks = append(hunks, cur)
+			cur = span{idx, idx + 1}
+		}
+	}
+	hunks = append(hunks, cur)
+
+	// Old/new line numbers at each op index, for hunk headers.
+	oldAt := make([]int, len(ops)+1)
+	newAt := make([]int, len(ops)+1)
+	o, n := 1, 1
+	for i, op := range ops {
+		oldAt[i], newAt[i] = o, n
+		switch op.kind {
+		case ' ':
+			o++
+			n++
+		case '-':
+			o++
+		case '+':
+			n++
+		}
+	}
+	oldAt[len(ops)], newAt[len(ops)] = o, n
+
+	var sb strings.Builder
+	for _, h := range hunks {
+		start := h.start - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := h.end + diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				oldCount++
+				newCount++
+			case '-':
+				oldCount++
+			case '+':
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldAt[start], oldCount, newAt[start], newCount)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
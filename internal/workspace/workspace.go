@@ -0,0 +1,1258 @@
+// Package workspace manages agentic's persistent state: staged changes
+// awaiting review, dirty-node tracking, git checkpoints, and the
+// last-applied snapshot used by undo.
+//
+// State is persisted as JSON under .agentic/state.json.
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aid/agentic/internal/graph"
+)
+
+// DataDir returns the base directory for agentic state (state file,
+// snapshots, contract hashes, bundle cache). It defaults to ".agentic"
+// in the current directory and can be relocated with the AGENTIC_HOME
+// environment variable, for read-only checkouts and CI.
+func DataDir() string {
+	if dir := os.Getenv("AGENTIC_HOME"); dir != "" {
+		return dir
+	}
+	return ".agentic"
+}
+
+// StatePath returns where workspace state is persisted.
+func StatePath() string {
+	return filepath.Join(DataDir(), "state.json")
+}
+
+// Checkpoint records a git SHA taken before applying changes.
+type Checkpoint struct {
+	Label     string    `json:"label"`
+	SHA       string    `json:"sha"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeletedFile is the staged-content sentinel marking a path for
+// deletion on apply. It contains NUL bytes so it cannot collide with
+// legitimate text content.
+const DeletedFile = "\x00agentic:deleted\x00"
+
+// FileBackup captures a file's state before it was overwritten.
+type FileBackup struct {
+	Existed bool   `json:"existed"`
+	Content string `json:"content"`
+}
+
+// LastApplied is the snapshot taken by the most recent apply, used by
+// Undo to restore the previous state and by Redo to re-write it.
+type LastApplied struct {
+	Nodes   []string              `json:"nodes"`
+	Backups map[string]FileBackup `json:"backups"`
+	// After holds the contents the apply wrote, so an undone apply can
+	// be redone without re-running the brain.
+	After     map[string]string `json:"after,omitempty"`
+	AppliedAt time.Time         `json:"applied_at"`
+}
+
+// DefaultUndoDepth bounds the undo stack; older applies fall off the
+// bottom. Overridable with AGENTIC_UNDO_DEPTH.
+const DefaultUndoDepth = 10
+
+// undoDepth resolves the undo stack cap.
+func undoDepth() int {
+	if raw := os.Getenv("AGENTIC_UNDO_DEPTH"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultUndoDepth
+}
+
+// StagedInfo records where a node's staged changes came from: the
+// originating request, the brain's prose message, and the reported
+// call cost.
+type StagedInfo struct {
+	Request string  `json:"request,omitempty"`
+	Message string  `json:"message,omitempty"`
+	Cost    float64 `json:"cost,omitempty"`
+}
+
+// CurrentSchemaVersion is the state.json format this binary writes.
+// Version history:
+//
+//	0 (implicit): single last_applied snapshot, no undo stack
+//	1: undo/redo stacks, staged base hashes
+const CurrentSchemaVersion = 1
+
+// Workspace is the persistent agentic state for a project.
+type Workspace struct {
+	// SchemaVersion records the state.json format, so older files can
+	// be migrated on load and newer ones rejected cleanly.
+	SchemaVersion int `json:"schema_version"`
+	// StagedChanges maps node ID -> file path -> new content.
+	StagedChanges map[string]map[string]string `json:"staged_changes"`
+	// StagedBase maps node ID -> file path -> hash of the on-disk
+	// content at stage time ("" when the file did not exist), so apply
+	// can detect files edited after staging.
+	StagedBase map[string]map[string]string `json:"staged_base,omitempty"`
+	// StagedInfo maps node ID -> provenance of its staged changes,
+	// used to build self-documenting commit messages on apply.
+	StagedInfo map[string]StagedInfo `json:"staged_info,omitempty"`
+	// DirtyNodes maps node ID -> reason it is considered dirty.
+	DirtyNodes  map[string]string `json:"dirty_nodes"`
+	Checkpoints []Checkpoint      `json:"checkpoints"`
+	// UndoStack holds snapshots of past applies, newest last; Undo pops
+	// from the top.
+	UndoStack []LastApplied `json:"undo_stack,omitempty"`
+	// RedoStack holds undone applies awaiting redo; any new staging or
+	// apply invalidates it.
+	RedoStack []LastApplied `json:"redo_stack,omitempty"`
+	// LastApplied is the pre-stack snapshot location, kept so old state
+	// files migrate into UndoStack on load.
+	LastApplied *LastApplied `json:"last_applied,omitempty"`
+	CurrentNode string       `json:"current_node,omitempty"`
+
+	mu sync.Mutex
+}
+
+// lockPath returns the advisory lock file guarding state.json.
+func lockPath() string {
+	return filepath.Join(DataDir(), "state.lock")
+}
+
+// stateLock is the process-wide flock handle on the workspace. flock
+// conflicts between file descriptors even within one process, so the
+// handle is shared: a second Load in the same process reuses it rather
+// than deadlocking against itself.
+var (
+	stateLockMu sync.Mutex
+	stateLock   *os.File
+)
+
+// acquireStateLock takes the advisory exclusive lock on the workspace,
+// failing fast when another process holds it. No-op when this process
+// already holds the lock.
+func acquireStateLock() error {
+	stateLockMu.Lock()
+	defer stateLockMu.Unlock()
+	if stateLock != nil {
+		return nil
+	}
+	if err := os.MkdirAll(DataDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(lockPath(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("opening workspace lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return fmt.Errorf("workspace is locked by another agentic process (%s)", lockPath())
+	}
+	stateLock = f
+	return nil
+}
+
+// releaseStateLock drops the advisory lock if this process holds it.
+// The OS also releases it automatically when the process exits.
+func releaseStateLock() {
+	stateLockMu.Lock()
+	defer stateLockMu.Unlock()
+	if stateLock == nil {
+		return
+	}
+	syscall.Flock(int(stateLock.Fd()), syscall.LOCK_UN)
+	stateLock.Close()
+	stateLock = nil
+}
+
+// Load reads the workspace state, returning a fresh workspace when no
+// state file exists yet. Load takes the workspace lock, held until the
+// next Save, so two agentic processes cannot clobber each other's
+// state rewrites.
+func Load() (*Workspace, error) {
+	if err := acquireStateLock(); err != nil {
+		return nil, err
+	}
+	ws := &Workspace{
+		StagedChanges: make(map[string]map[string]string),
+		StagedBase:    make(map[string]map[string]string),
+		DirtyNodes:    make(map[string]string),
+	}
+	data, err := os.ReadFile(StatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ws, nil
+		}
+		return nil, fmt.Errorf("reading workspace state: %w", err)
+	}
+	if err := json.Unmarshal(data, ws); err != nil {
+		return nil, fmt.Errorf("parsing workspace state: %w", err)
+	}
+	if ws.SchemaVersion > CurrentSchemaVersion {
+		return nil, fmt.Errorf("workspace state %s is schema version %d, but this binary only understands up to %d; upgrade agentic",
+			StatePath(), ws.SchemaVersion, CurrentSchemaVersion)
+	}
+	if ws.StagedChanges == nil {
+		ws.StagedChanges = make(map[string]map[string]string)
+	}
+	if ws.DirtyNodes == nil {
+		ws.DirtyNodes = make(map[string]string)
+	}
+	if ws.StagedBase == nil {
+		ws.StagedBase = make(map[string]map[string]string)
+	}
+	ws.migrate()
+	return ws, nil
+}
+
+// migrate upgrades older state formats in place; Save then writes the
+// current version.
+func (ws *Workspace) migrate() {
+	if ws.SchemaVersion < 1 {
+		// v0 kept a single last_applied snapshot; fold it into the
+		// undo stack.
+		if ws.LastApplied != nil {
+			ws.UndoStack = append(ws.UndoStack, *ws.LastApplied)
+			ws.LastApplied = nil
+		}
+	}
+	ws.SchemaVersion = CurrentSchemaVersion
+}
+
+// pushUndo appends an apply snapshot, dropping the oldest entries when
+// the stack exceeds its cap. Callers must not hold ws.mu.
+func (ws *Workspace) pushUndo(applied LastApplied) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.UndoStack = append(ws.UndoStack, applied)
+	if depth := undoDepth(); len(ws.UndoStack) > depth {
+		ws.UndoStack = ws.UndoStack[len(ws.UndoStack)-depth:]
+	}
+	ws.RedoStack = nil
+}
+
+// Save persists the workspace state and releases the workspace lock
+// taken by Load. Saves without a prior Load re-acquire it for the
+// duration of the write.
+//
+// The mutex is held for the marshal so the written bytes are a
+// consistent snapshot even while parallel goroutines are still calling
+// StageFiles; the file write itself happens outside the lock.
+func (ws *Workspace) Save() error {
+	if err := acquireStateLock(); err != nil {
+		return err
+	}
+	defer releaseStateLock()
+	ws.mu.Lock()
+	ws.SchemaVersion = CurrentSchemaVersion
+	data, err := json.MarshalIndent(ws, "", "  ")
+	ws.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(DataDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(StatePath(), data, 0644)
+}
+
+// StageFiles replaces a node's staged file set, recording the hash of
+// each path's current on-disk content for later drift detection. Keys
+// are repo-relative paths, resolved against the working tree as-is.
+func (ws *Workspace) StageFiles(nodeID string, files map[string]string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	staged := make(map[string]string, len(files))
+	base := make(map[string]string, len(files))
+	for path, content := range files {
+		staged[path] = content
+		base[path] = hashFile(path)
+	}
+	ws.StagedChanges[nodeID] = staged
+	if ws.StagedBase == nil {
+		ws.StagedBase = make(map[string]map[string]string)
+	}
+	ws.StagedBase[nodeID] = base
+	ws.RedoStack = nil
+}
+
+// StageFile adds or replaces a single staged file for a node, leaving
+// the rest of the node's staged set intact.
+func (ws *Workspace) StageFile(nodeID, path, content string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.StagedChanges[nodeID] == nil {
+		ws.StagedChanges[nodeID] = make(map[string]string)
+	}
+	ws.StagedChanges[nodeID][path] = content
+	if ws.StagedBase == nil {
+		ws.StagedBase = make(map[string]map[string]string)
+	}
+	if ws.StagedBase[nodeID] == nil {
+		ws.StagedBase[nodeID] = make(map[string]string)
+	}
+	ws.StagedBase[nodeID][path] = hashFile(path)
+	ws.RedoStack = nil
+}
+
+// hashFile returns the hash of a file's current content, or "" when
+// the file does not exist.
+func hashFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// DriftedFiles returns the staged paths whose on-disk content changed
+// after they were staged, sorted. Paths staged before drift tracking
+// existed have no recorded base and are never reported.
+func (ws *Workspace) DriftedFiles(nodes []string) []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	seen := make(map[string]bool)
+	var drifted []string
+	for _, nodeID := range nodes {
+		for path, base := range ws.StagedBase[nodeID] {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if hashFile(path) != base {
+				drifted = append(drifted, path)
+			}
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// UnstageFile drops a single file from a node's staged changes, so
+// unwanted files from a brain response can be cherry-picked out before
+// applying. Removing the last file clears the node's entry.
+func (ws *Workspace) UnstageFile(nodeID, path string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	files, ok := ws.StagedChanges[nodeID]
+	if !ok || len(files) == 0 {
+		return fmt.Errorf("no staged changes for node %s", nodeID)
+	}
+	if _, ok := files[path]; !ok {
+		return fmt.Errorf("file %s is not staged for node %s", path, nodeID)
+	}
+	delete(files, path)
+	if base, ok := ws.StagedBase[nodeID]; ok {
+		delete(base, path)
+	}
+	if len(files) == 0 {
+		delete(ws.StagedChanges, nodeID)
+		delete(ws.StagedBase, nodeID)
+	}
+	return nil
+}
+
+// ClearStaged drops the staged changes for one node.
+func (ws *Workspace) ClearStaged(nodeID string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.StagedChanges, nodeID)
+	delete(ws.StagedBase, nodeID)
+	delete(ws.StagedInfo, nodeID)
+}
+
+// SetStagedInfo records the provenance of a node's staged changes.
+func (ws *Workspace) SetStagedInfo(nodeID string, info StagedInfo) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.StagedInfo == nil {
+		ws.StagedInfo = make(map[string]StagedInfo)
+	}
+	ws.StagedInfo[nodeID] = info
+}
+
+// StagedInfoFor returns the recorded provenance of a node's staged
+// changes, zero when none was recorded.
+func (ws *Workspace) StagedInfoFor(nodeID string) StagedInfo {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.StagedInfo[nodeID]
+}
+
+// MarkDirty records a node as dirty with a reason.
+func (ws *Workspace) MarkDirty(nodeID, reason string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.DirtyNodes[nodeID] = reason
+}
+
+// StagedNodes returns the node IDs with staged changes, sorted.
+func (ws *Workspace) StagedNodes() []string {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	nodes := make([]string, 0, len(ws.StagedChanges))
+	for id := range ws.StagedChanges {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// ApplyOptions controls how ApplyChanges writes staged changes.
+type ApplyOptions struct {
+	// Atomic snapshots every original first and restores them all if
+	// any write or validation fails, making the apply all-or-nothing.
+	Atomic bool
+	// Force applies even when staged files drifted on disk after
+	// staging.
+	Force bool
+	// Validate, when set, runs per node after all files are written
+	// and before the apply is finalized (e.g. node checks). An error
+	// aborts the apply and, with Atomic, rolls the writes back.
+	Validate func(nodeID string, written []string) error
+	// Nodes, when non-empty, restricts the apply to these staged node
+	// IDs, leaving the rest staged. The undo snapshot covers only the
+	// applied set.
+	Nodes []string
+	// OutputDir, when set, mirrors the writes under this directory
+	// (preserving relative paths) instead of touching the originals.
+	// Staging is preserved so a real apply can follow; deletions are
+	// skipped since a mirror cannot represent them.
+	OutputDir string
+}
+
+// ApplyChanges writes all staged changes to disk, capturing previous
+// file contents in the undo stack so the operation can be undone. When
+// several nodes staged the same path, the edits are three-way merged
+// against the on-disk base; true conflicts abort the apply. Staging is
+// cleared for applied nodes. Returns the list of written paths.
+func (ws *Workspace) ApplyChanges(opts ApplyOptions) ([]string, error) {
+	nodes := ws.StagedNodes()
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no staged changes to apply")
+	}
+	if len(opts.Nodes) > 0 {
+		isStaged := make(map[string]bool, len(nodes))
+		for _, id := range nodes {
+			isStaged[id] = true
+		}
+		seen := make(map[string]bool, len(opts.Nodes))
+		nodes = nodes[:0]
+		for _, id := range opts.Nodes {
+			if !isStaged[id] {
+				return nil, fmt.Errorf("node %q has no staged changes", id)
+			}
+			if !seen[id] {
+				seen[id] = true
+				nodes = append(nodes, id)
+			}
+		}
+		sort.Strings(nodes)
+	}
+
+	if !opts.Force {
+		if drifted := ws.DriftedFiles(nodes); len(drifted) > 0 {
+			return nil, fmt.Errorf("file(s) changed on disk since staging: %s (re-stage or use --force)",
+				strings.Join(drifted, ", "))
+		}
+	}
+
+	merged, err := ws.mergeOverlappingEdits(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.OutputDir != "" {
+		return ws.applyToMirror(opts.OutputDir, nodes, merged)
+	}
+
+	applied := &LastApplied{
+		Nodes:     nodes,
+		Backups:   make(map[string]FileBackup),
+		After:     make(map[string]string),
+		AppliedAt: time.Now(),
+	}
+
+	// Build the full write plan and snapshot every original before
+	// touching the first file.
+	type writeOp struct {
+		path    string
+		content string
+	}
+	var plan []writeOp
+	byNode := make(map[string][]string)
+	for _, nodeID := range nodes {
+		files := ws.StagedChanges[nodeID]
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		byNode[nodeID] = paths
+		for _, path := range paths {
+			if _, done := applied.Backups[path]; done {
+				// Already planned via an earlier node (merged path).
+				continue
+			}
+			prev, err := os.ReadFile(path)
+			if err == nil {
+				applied.Backups[path] = FileBackup{Existed: true, Content: string(prev)}
+			} else {
+				applied.Backups[path] = FileBackup{Existed: false}
+			}
+			content := files[path]
+			if m, ok := merged[path]; ok {
+				content = m
+			}
+			plan = append(plan, writeOp{path, content})
+		}
+	}
+
+	var written []string
+	for _, op := range plan {
+		err := applyOne(op.path, op.content)
+		if err != nil {
+			if opts.Atomic {
+				restored := ws.restoreBackups(written, applied.Backups)
+				return nil, fmt.Errorf("writing %s: %v; rolled back %d already-written file(s)",
+					op.path, err, restored)
+			}
+			return written, fmt.Errorf("writing %s: %w", op.path, err)
+		}
+		applied.After[op.path] = op.content
+		written = append(written, op.path)
+	}
+
+	// Validate against the newly written files before finalizing, so a
+	// failing check can still roll the whole apply back.
+	if opts.Validate != nil {
+		for _, nodeID := range nodes {
+			if err := opts.Validate(nodeID, byNode[nodeID]); err != nil {
+				if opts.Atomic {
+					restored := ws.restoreBackups(written, applied.Backups)
+					return nil, fmt.Errorf("%w; rolled back %d written file(s)", err, restored)
+				}
+				return written, err
+			}
+		}
+	}
+
+	for _, nodeID := range nodes {
+		ws.ClearStaged(nodeID)
+	}
+
+	ws.pushUndo(*applied)
+
+	if err := ws.Save(); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// applyToMirror writes the staged (and merged) contents under dir,
+// preserving relative paths, without backups, undo history, or staging
+// clears — the real tree is untouched, so there is nothing to revert.
+// Returns the mirror paths written.
+func (ws *Workspace) applyToMirror(dir string, nodes []string, merged map[string]string) ([]string, error) {
+	seen := make(map[string]bool)
+	var written []string
+	for _, nodeID := range nodes {
+		files := ws.StagedChanges[nodeID]
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			content := files[path]
+			if m, ok := merged[path]; ok {
+				content = m
+			}
+			if content == DeletedFile {
+				fmt.Printf("Note: %s is staged for deletion; not represented in %s\n", path, dir)
+				continue
+			}
+			out := filepath.Join(dir, path)
+			if err := WriteFile(out, content); err != nil {
+				return written, fmt.Errorf("writing %s: %w", out, err)
+			}
+			written = append(written, out)
+		}
+	}
+	return written, nil
+}
+
+// applyOne writes or deletes a single path according to its staged
+// content.
+func applyOne(path, content string) error {
+	if content == DeletedFile {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return WriteFile(path, content)
+}
+
+// restoreBackups puts already-written paths back to their snapshotted
+// state after a failed atomic apply. Returns how many were restored;
+// restore errors are reported but do not stop the remaining restores.
+func (ws *Workspace) restoreBackups(written []string, backups map[string]FileBackup) int {
+	restored := 0
+	for _, path := range written {
+		backup := backups[path]
+		var err error
+		if backup.Existed {
+			err = WriteFile(path, backup.Content)
+		} else if err = os.Remove(path); os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: could not restore %s: %v\n", path, err)
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+// ApplyNode writes a single node's staged changes to disk, clearing its
+// staging. With extend, backups accumulate into the existing LastApplied
+// snapshot so a sequence of per-node applies undoes as one unit;
+// otherwise a fresh snapshot is started. Returns the written paths.
+func (ws *Workspace) ApplyNode(nodeID string, extend bool) ([]string, error) {
+	ws.mu.Lock()
+	files := ws.StagedChanges[nodeID]
+	ws.mu.Unlock()
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no staged changes for node %s", nodeID)
+	}
+
+	ws.mu.Lock()
+	var applied *LastApplied
+	if extend && len(ws.UndoStack) > 0 {
+		applied = &ws.UndoStack[len(ws.UndoStack)-1]
+	} else {
+		ws.UndoStack = append(ws.UndoStack, LastApplied{
+			Backups: make(map[string]FileBackup),
+			After:   make(map[string]string),
+		})
+		if depth := undoDepth(); len(ws.UndoStack) > depth {
+			ws.UndoStack = ws.UndoStack[len(ws.UndoStack)-depth:]
+		}
+		applied = &ws.UndoStack[len(ws.UndoStack)-1]
+	}
+	if applied.After == nil {
+		applied.After = make(map[string]string)
+	}
+	applied.Nodes = append(applied.Nodes, nodeID)
+	applied.AppliedAt = time.Now()
+	ws.RedoStack = nil
+	ws.mu.Unlock()
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var written []string
+	for _, path := range paths {
+		if _, done := applied.Backups[path]; !done {
+			prev, err := os.ReadFile(path)
+			if err == nil {
+				applied.Backups[path] = FileBackup{Existed: true, Content: string(prev)}
+			} else {
+				applied.Backups[path] = FileBackup{Existed: false}
+			}
+		}
+		if files[path] == DeletedFile {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return written, fmt.Errorf("deleting %s: %w", path, err)
+			}
+		} else if err := WriteFile(path, files[path]); err != nil {
+			return written, fmt.Errorf("writing %s: %w", path, err)
+		}
+		applied.After[path] = files[path]
+		written = append(written, path)
+	}
+	ws.ClearStaged(nodeID)
+
+	if err := ws.Save(); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// mergeOverlappingEdits finds paths staged by more than one node and
+// three-way merges the variants against the on-disk base. Last-write-
+// wins silently losing one node's edit is never acceptable; edits to
+// overlapping regions are reported as conflicts for manual resolution.
+func (ws *Workspace) mergeOverlappingEdits(nodes []string) (map[string]string, error) {
+	stagedBy := make(map[string][]string)
+	for _, nodeID := range nodes {
+		for path := range ws.StagedChanges[nodeID] {
+			stagedBy[path] = append(stagedBy[path], nodeID)
+		}
+	}
+
+	merged := make(map[string]string)
+	for path, owners := range stagedBy {
+		if len(owners) < 2 {
+			continue
+		}
+		sort.Strings(owners)
+		base := ""
+		if data, err := os.ReadFile(path); err == nil {
+			base = string(data)
+		}
+		result := ws.StagedChanges[owners[0]][path]
+		for _, nodeID := range owners[1:] {
+			next, ok := mergeThreeWay(base, result, ws.StagedChanges[nodeID][path])
+			if !ok {
+				return nil, fmt.Errorf("conflicting staged edits to %s from nodes %s; unstage one and resolve manually",
+					path, strings.Join(owners, ", "))
+			}
+			result = next
+		}
+		merged[path] = result
+	}
+	return merged, nil
+}
+
+// mergeThreeWay merges two edited versions of a base. Each variant's
+// edit is localized to the line region outside the common prefix and
+// suffix it shares with the base; when the two regions are disjoint,
+// both edits apply. Overlapping regions report a conflict.
+func mergeThreeWay(base, a, b string) (string, bool) {
+	if a == b {
+		return a, true
+	}
+	if a == base {
+		return b, true
+	}
+	if b == base {
+		return a, true
+	}
+
+	baseLines := strings.Split(base, "\n")
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+
+	aStart, aEnd := editRegion(baseLines, aLines)
+	bStart, bEnd := editRegion(baseLines, bLines)
+
+	// Regions are half-open [start, end) over base lines.
+	if aStart > bStart {
+		aLines, bLines = bLines, aLines
+		aStart, aEnd, bStart, bEnd = bStart, bEnd, aStart, aEnd
+	}
+	if aEnd > bStart {
+		return "", false
+	}
+
+	// Replace the later region first so earlier indexes stay valid.
+	out := append([]string(nil), baseLines...)
+	out = spliceLines(out, bStart, bEnd, bLines[bStart:len(bLines)-(len(baseLines)-bEnd)])
+	out = spliceLines(out, aStart, aEnd, aLines[aStart:len(aLines)-(len(baseLines)-aEnd)])
+	return strings.Join(out, "\n"), true
+}
+
+// editRegion returns the half-open line range of base that variant
+// modified, computed from their common prefix and suffix.
+func editRegion(base, variant []string) (int, int) {
+	prefix := 0
+	for prefix < len(base) && prefix < len(variant) && base[prefix] == variant[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(variant)-prefix &&
+		base[len(base)-1-suffix] == variant[len(variant)-1-suffix] {
+		suffix++
+	}
+	return prefix, len(base) - suffix
+}
+
+// spliceLines replaces lines[start:end] with replacement.
+func spliceLines(lines []string, start, end int, replacement []string) []string {
+	out := make([]string, 0, len(lines)-(end-start)+len(replacement))
+	out = append(out, lines[:start]...)
+	out = append(out, replacement...)
+	out = append(out, lines[end:]...)
+	return out
+}
+
+// WriteFile writes content to path, creating parent directories.
+func WriteFile(path, content string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// Undo restores the files recorded in the newest undo snapshot to
+// their previous contents and pops it off the stack, so repeated undos
+// walk back through apply history. With dryRun it only reports what
+// would be restored, noting files whose current content already
+// differs from what the apply wrote.
+func (ws *Workspace) Undo(dryRun bool) error {
+	ws.mu.Lock()
+	var applied *LastApplied
+	if len(ws.UndoStack) > 0 {
+		applied = &ws.UndoStack[len(ws.UndoStack)-1]
+	}
+	ws.mu.Unlock()
+
+	if applied == nil {
+		return fmt.Errorf("nothing to undo")
+	}
+
+	if dryRun {
+		fmt.Printf("Undo would revert %d file(s) from the apply at %s:\n",
+			len(applied.Backups), applied.AppliedAt.Format(time.RFC3339))
+		paths := make([]string, 0, len(applied.Backups))
+		for path := range applied.Backups {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			backup := applied.Backups[path]
+			action := "restore previous content"
+			if !backup.Existed {
+				action = "delete (did not exist before apply)"
+			}
+			note := ""
+			if current, err := os.ReadFile(path); err == nil {
+				if backup.Existed && string(current) == backup.Content {
+					note = " (already matches backup)"
+				}
+			} else if os.IsNotExist(err) {
+				note = " (currently missing)"
+			}
+			fmt.Printf("  %s: %s%s\n", path, action, note)
+		}
+		return nil
+	}
+
+	paths := make([]string, 0, len(applied.Backups))
+	for path := range applied.Backups {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		backup := applied.Backups[path]
+		if backup.Existed {
+			if err := WriteFile(path, backup.Content); err != nil {
+				return fmt.Errorf("restoring %s: %w", path, err)
+			}
+		} else {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("removing %s: %w", path, err)
+			}
+		}
+	}
+
+	ws.mu.Lock()
+	entry := ws.UndoStack[len(ws.UndoStack)-1]
+	ws.UndoStack = ws.UndoStack[:len(ws.UndoStack)-1]
+	ws.RedoStack = append(ws.RedoStack, entry)
+	ws.mu.Unlock()
+	return ws.Save()
+}
+
+// RecordApplied pushes an externally performed file operation (such as
+// a split moving files) onto the undo stack, so undo and redo treat it
+// like an apply.
+func (ws *Workspace) RecordApplied(applied LastApplied) error {
+	ws.pushUndo(applied)
+	return ws.Save()
+}
+
+// LastApply returns a copy of the most recent apply snapshot, or nil
+// when nothing has been applied. Used by apply --recheck to re-verify
+// an apply whose build failed, after a manual fix.
+func (ws *Workspace) LastApply() *LastApplied {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.UndoStack) == 0 {
+		return nil
+	}
+	entry := ws.UndoStack[len(ws.UndoStack)-1]
+	return &entry
+}
+
+// Redo re-writes the files reverted by the most recent undo and pushes
+// the snapshot back onto the undo stack. Redo data is invalidated by
+// any staging or apply after the undo.
+func (ws *Workspace) Redo() error {
+	ws.mu.Lock()
+	var entry *LastApplied
+	if len(ws.RedoStack) > 0 {
+		entry = &ws.RedoStack[len(ws.RedoStack)-1]
+	}
+	ws.mu.Unlock()
+
+	if entry == nil {
+		return fmt.Errorf("nothing to redo")
+	}
+	if len(entry.After) == 0 {
+		return fmt.Errorf("undo entry predates redo support; re-run the task instead")
+	}
+
+	paths := make([]string, 0, len(entry.After))
+	for path := range entry.After {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if entry.After[path] == DeletedFile {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("re-deleting %s: %w", path, err)
+			}
+		} else if err := WriteFile(path, entry.After[path]); err != nil {
+			return fmt.Errorf("re-writing %s: %w", path, err)
+		}
+	}
+
+	ws.mu.Lock()
+	redone := ws.RedoStack[len(ws.RedoStack)-1]
+	ws.RedoStack = ws.RedoStack[:len(ws.RedoStack)-1]
+	ws.UndoStack = append(ws.UndoStack, redone)
+	if depth := undoDepth(); len(ws.UndoStack) > depth {
+		ws.UndoStack = ws.UndoStack[len(ws.UndoStack)-depth:]
+	}
+	ws.mu.Unlock()
+	return ws.Save()
+}
+
+// snapshotsDir returns where named workspace snapshots are kept.
+func snapshotsDir() string {
+	return filepath.Join(DataDir(), "snapshots")
+}
+
+// snapshotPath returns the file for a named snapshot, rejecting names
+// that would escape the snapshots directory.
+func snapshotPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return filepath.Join(snapshotsDir(), name+".json"), nil
+}
+
+// SnapshotSave copies the entire workspace state (staged changes,
+// dirty nodes, checkpoints, undo data) to a named snapshot, so a risky
+// session can be rolled back as a unit independent of git.
+func (ws *Workspace) SnapshotSave(name string) error {
+	path, err := snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if err := ws.Save(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(StatePath())
+	if err != nil {
+		return fmt.Errorf("reading workspace state: %w", err)
+	}
+	if err := os.MkdirAll(snapshotsDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// SnapshotRestore replaces the current workspace state with a named
+// snapshot. The caller should reload the workspace afterwards.
+func SnapshotRestore(name string) error {
+	path, err := snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no snapshot named %q", name)
+		}
+		return err
+	}
+	// Validate before overwriting the live state.
+	var check Workspace
+	if err := json.Unmarshal(data, &check); err != nil {
+		return fmt.Errorf("snapshot %q is corrupt: %w", name, err)
+	}
+	if err := os.MkdirAll(DataDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(StatePath(), data, 0644)
+}
+
+// SnapshotList returns the names of saved snapshots, sorted.
+func SnapshotList() ([]string, error) {
+	entries, err := os.ReadDir(snapshotsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// IsGitRepo reports whether the current directory is inside a git
+// working tree.
+func IsGitRepo() bool {
+	err := exec.Command("git", "rev-parse", "--is-inside-work-tree").Run()
+	return err == nil
+}
+
+// dirtyReasonGit marks dirty entries derived from git status, so a
+// refresh can clear stale ones without touching manual marks.
+const dirtyReasonGit = "uncommitted changes"
+
+// RefreshDirtyFromGit re-derives dirty nodes from the git working
+// tree: files reported by git status --porcelain are mapped to the
+// owning node by path prefix and those nodes are marked dirty. Nodes
+// previously marked from git but now clean are unmarked; files under
+// no node are ignored.
+func (ws *Workspace) RefreshDirtyFromGit(g *graph.Graph) error {
+	if !IsGitRepo() {
+		return fmt.Errorf("not a git repository")
+	}
+	out, err := exec.Command("git", "status", "--porcelain").Output()
+	if err != nil {
+		return fmt.Errorf("git status: %w", err)
+	}
+
+	dirty := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		path := strings.TrimSpace(line[3:])
+		// Renames are reported as "old -> new"; the new path is what
+		// lives in the tree now.
+		if i := strings.Index(path, " -> "); i >= 0 {
+			path = path[i+4:]
+		}
+		path = strings.Trim(path, `"`)
+		for _, node := range g.Nodes {
+			if path == node.Path || strings.HasPrefix(path, node.Path+"/") {
+				dirty[node.ID] = true
+			}
+		}
+	}
+
+	ws.mu.Lock()
+	for id, reason := range ws.DirtyNodes {
+		if reason == dirtyReasonGit && !dirty[id] {
+			delete(ws.DirtyNodes, id)
+		}
+	}
+	for id := range dirty {
+		ws.DirtyNodes[id] = dirtyReasonGit
+	}
+	ws.mu.Unlock()
+	return nil
+}
+
+// GitCommit stages the given paths and commits them with the message.
+// Deleted paths are staged as removals via git add -A.
+func GitCommit(message string, paths []string) error {
+	if !IsGitRepo() {
+		return fmt.Errorf("not a git repository")
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("nothing to commit")
+	}
+	args := append([]string{"add", "-A", "--"}, paths...)
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("git add: %v\n%s", err, out)
+	}
+	if out, err := exec.Command("git", "commit", "-m", message).CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// CreateCheckpoint records the current git HEAD under a label.
+func (ws *Workspace) CreateCheckpoint(label string) error {
+	if !IsGitRepo() {
+		return fmt.Errorf("not a git repository")
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("resolving HEAD: %w", err)
+	}
+	ws.mu.Lock()
+	ws.Checkpoints = append(ws.Checkpoints, Checkpoint{
+		Label:     label,
+		SHA:       strings.TrimSpace(string(out)),
+		CreatedAt: time.Now(),
+	})
+	ws.mu.Unlock()
+	return ws.Save()
+}
+
+// Rollback hard-resets the git working tree to the named checkpoint.
+// With dryRun it lists the files that would be reverted instead.
+func (ws *Workspace) Rollback(label string, dryRun bool) error {
+	ws.mu.Lock()
+	var target *Checkpoint
+	for i := range ws.Checkpoints {
+		if ws.Checkpoints[i].Label == label {
+			target = &ws.Checkpoints[i]
+		}
+	}
+	ws.mu.Unlock()
+
+	if target == nil {
+		return fmt.Errorf("no checkpoint named %q", label)
+	}
+
+	if dryRun {
+		out, err := exec.Command("git", "diff", "--name-only", target.SHA).Output()
+		if err != nil {
+			return fmt.Errorf("git diff failed: %w", err)
+		}
+		var files []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				files = append(files, line)
+			}
+		}
+		if len(files) == 0 {
+			fmt.Printf("Rollback to %q (%s) would change nothing.\n", label, target.SHA[:12])
+			return nil
+		}
+		fmt.Printf("Rollback to %q (%s) would revert %d file(s):\n", label, target.SHA[:12], len(files))
+		for _, file := range files {
+			fmt.Printf("  %s\n", file)
+		}
+		return nil
+	}
+
+	if out, err := exec.Command("git", "reset", "--hard", target.SHA).CombinedOutput(); err != nil {
+		return fmt.Errorf("git reset failed: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// PrintDiff shows the staged changes as unified diffs against the
+// current on-disk content, optionally limited to one node. New files
+// render entirely as additions.
+func (ws *Workspace) PrintDiff(nodeID string) {
+	nodes := ws.StagedNodes()
+	if nodeID != "" {
+		nodes = []string{nodeID}
+	}
+	for _, id := range nodes {
+		files, ok := ws.StagedChanges[id]
+		if !ok || len(files) == 0 {
+			continue
+		}
+		fmt.Printf("--- node %s (%d files) ---\n", id, len(files))
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			staged := files[path]
+			current, err := os.ReadFile(path)
+			switch {
+			case staged == DeletedFile:
+				fmt.Printf("\n  %s (deleted):\n", path)
+				if err == nil {
+					lines := strings.Split(strings.TrimSuffix(string(current), "\n"), "\n")
+					fmt.Printf("    @@ -1,%d +0,0 @@\n", len(lines))
+					for _, line := range lines {
+						fmt.Printf("    -%s\n", line)
+					}
+				}
+			case err != nil:
+				fmt.Printf("\n  %s (new file):\n", path)
+				lines := strings.Split(strings.TrimSuffix(staged, "\n"), "\n")
+				fmt.Printf("    @@ -0,0 +1,%d @@\n", len(lines))
+				for _, line := range lines {
+					fmt.Printf("    +%s\n", line)
+				}
+			case string(current) == staged:
+				fmt.Printf("\n  %s: (unchanged)\n", path)
+			default:
+				fmt.Printf("\n  %s:\n", path)
+				for _, line := range strings.Split(strings.TrimSuffix(UnifiedDiff(string(current), staged), "\n"), "\n") {
+					fmt.Printf("    %s\n", line)
+				}
+			}
+		}
+	}
+}
+
+// PrintStatus summarizes the workspace state.
+func (ws *Workspace) PrintStatus() {
+	staged := ws.StagedNodes()
+	if len(staged) == 0 {
+		fmt.Println("No staged changes")
+	} else {
+		fmt.Printf("Staged changes in %d node(s):\n", len(staged))
+		for _, id := range staged {
+			fmt.Printf("  %s: %d file(s)\n", id, len(ws.StagedChanges[id]))
+		}
+	}
+
+	if len(ws.DirtyNodes) > 0 {
+		ids := make([]string, 0, len(ws.DirtyNodes))
+		for id := range ws.DirtyNodes {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Printf("Dirty nodes:\n")
+		for _, id := range ids {
+			fmt.Printf("  %s: %s\n", id, ws.DirtyNodes[id])
+		}
+	}
+
+	if len(ws.Checkpoints) > 0 {
+		fmt.Printf("Checkpoints: %d (latest %q)\n",
+			len(ws.Checkpoints), ws.Checkpoints[len(ws.Checkpoints)-1].Label)
+	}
+	if len(ws.UndoStack) > 0 {
+		top := ws.UndoStack[len(ws.UndoStack)-1]
+		fmt.Printf("Last apply: %d file(s) at %s (%d undo step(s) available)\n",
+			len(top.Backups), top.AppliedAt.Format(time.RFC3339), len(ws.UndoStack))
+	}
+	if len(ws.RedoStack) > 0 {
+		fmt.Printf("Redo: %d step(s) available\n", len(ws.RedoStack))
+	}
+	if ws.CurrentNode != "" {
+		fmt.Printf("Current node: %s\n", ws.CurrentNode)
+	}
+}
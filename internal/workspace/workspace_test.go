@@ -0,0 +1,131 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestWriteFile covers the directory derivation: top-level files must
+// not create a spurious directory, nested paths must create their
+// parents, and dots in file names must not be mistaken for structure.
+func TestWriteFile(t *testing.T) {
+	root := t.TempDir()
+	prev, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+
+	cases := []struct {
+		name string
+		path string
+	}{
+		{"top-level", "main.go"},
+		{"dot-slash", "./config.yaml"},
+		{"nested", "a/b/c/file.go"},
+		{"dotted name", "pkg/v1.2/schema.pb.go"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := WriteFile(tc.path, "content\n"); err != nil {
+				t.Fatalf("WriteFile(%q): %v", tc.path, err)
+			}
+			got, err := os.ReadFile(tc.path)
+			if err != nil {
+				t.Fatalf("reading back %q: %v", tc.path, err)
+			}
+			if string(got) != "content\n" {
+				t.Errorf("content = %q, want %q", got, "content\n")
+			}
+		})
+	}
+
+	// Top-level writes must not have created a directory named after
+	// the file.
+	if fi, err := os.Stat(filepath.Join(root, "main.go")); err != nil || fi.IsDir() {
+		t.Errorf("main.go should be a regular file (err=%v)", err)
+	}
+}
+
+// TestConcurrentStageAndSave exercises the parallel-run pattern: many
+// goroutines staging their own nodes while others save. Run with -race
+// to catch unsynchronized access to the staged maps during marshal.
+func TestConcurrentStageAndSave(t *testing.T) {
+	root := t.TempDir()
+	prev, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(prev) })
+
+	ws, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const nodes = 8
+	var wg sync.WaitGroup
+	for i := 0; i < nodes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("node-%d", i)
+			ws.StageFiles(id, map[string]string{
+				fmt.Sprintf("pkg%d/file.go", i): "package main\n",
+			})
+			if err := ws.Save(); err != nil {
+				t.Errorf("Save from %s: %v", id, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := ws.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.StagedChanges) != nodes {
+		t.Errorf("reloaded %d staged nodes, want %d", len(got.StagedChanges), nodes)
+	}
+}
+
+// TestDriftedFiles stages a file under its repo-relative key, then
+// modifies it on disk and expects drift to be reported. A key that
+// does not resolve to the file records an empty base hash and the
+// drift gate goes dead, which is exactly the regression this guards.
+func TestDriftedFiles(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "nodes", "demo", "SRC", "demo.go")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("package demo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := &Workspace{StagedChanges: make(map[string]map[string]string)}
+	ws.StageFiles("demo", map[string]string{path: "package demo\n\nfunc New() {}\n"})
+
+	if got := ws.DriftedFiles([]string{"demo"}); len(got) != 0 {
+		t.Fatalf("no drift yet, got %v", got)
+	}
+	if err := os.WriteFile(path, []byte("package demo // edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := ws.DriftedFiles([]string{"demo"})
+	if len(got) != 1 || got[0] != path {
+		t.Fatalf("DriftedFiles = %v, want [%s]", got, path)
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/aid/agentic/internal/cli"
+
+func main() {
+	cli.Execute()
+}
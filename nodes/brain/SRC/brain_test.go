@@ -0,0 +1 @@
+../../../internal/brain/brain_test.go
\ No newline at end of file
@@ -0,0 +1 @@
+../../../internal/bundle/bundle_test.go
\ No newline at end of file
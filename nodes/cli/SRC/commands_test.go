@@ -0,0 +1 @@
+../../../internal/cli/commands_test.go
\ No newline at end of file
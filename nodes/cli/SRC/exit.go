@@ -0,0 +1 @@
+../../../internal/cli/exit.go
\ No newline at end of file
@@ -0,0 +1 @@
+../../../internal/policy/api.go
\ No newline at end of file
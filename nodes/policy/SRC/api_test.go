@@ -0,0 +1 @@
+../../../internal/policy/api_test.go
\ No newline at end of file
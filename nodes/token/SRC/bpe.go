@@ -0,0 +1 @@
+../../../internal/token/bpe.go
\ No newline at end of file
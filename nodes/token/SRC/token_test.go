@@ -0,0 +1 @@
+../../../internal/token/token_test.go
\ No newline at end of file
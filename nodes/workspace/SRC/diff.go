@@ -0,0 +1 @@
+../../../internal/workspace/diff.go
\ No newline at end of file
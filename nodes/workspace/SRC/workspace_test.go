@@ -0,0 +1 @@
+../../../internal/workspace/workspace_test.go
\ No newline at end of file